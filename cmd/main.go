@@ -10,26 +10,37 @@ import (
 	"github.com/sequix/sup/pkg/log"
 	"github.com/sequix/sup/pkg/process"
 	"github.com/sequix/sup/pkg/run"
+	"github.com/sequix/sup/pkg/spawn"
 )
 
 func main() {
+	spawn.Main()
 	flag.Parse()
 	buildinfo.Init()
-	config.Init()
 
 	if len(flag.Args()) == 0 {
+		config.Init()
 		server()
 	} else {
+		config.InitClient()
 		client()
 	}
 }
 
 func server() {
 	stop := run.SetupSignalHandler()
+	reload := run.SetupReloadSignalHandler()
 	process.InitServer()
 	serverRw := run.Run(process.Serve)
 	log.Info("Sup daemon inited")
 
+	go func() {
+		for range reload {
+			log.Info("recv reload signal")
+			process.ReloadConfigOnSignal()
+		}
+	}()
+
 	<-stop
 	log.Info("recv term signal")
 
@@ -42,23 +53,37 @@ func client() {
 	process.InitClient()
 	defer process.ClientClose()
 
+	program := flag.Arg(1)
 	switch action := flag.Arg(0); action {
 	case process.ActionStart:
-		err = process.Start()
+		err = process.Start(program)
 	case process.ActionStop:
-		err = process.Stop()
+		err = process.Stop(program)
 	case process.ActionRestart:
-		err = process.Restart()
+		err = process.Restart(program)
 	case process.ActionReload:
-		err = process.Reload()
+		err = process.Reload(program)
 	case process.ActionKill:
-		err = process.Kill()
+		err = process.Kill(program)
 	case process.ActionStatus:
-		err = process.Status()
+		fs := flag.NewFlagSet(action, flag.ExitOnError)
+		output := fs.String("o", process.OutputTable, "output format, one of [table, json]")
+		args := parseFlags(fs, flag.Args()[1:])
+		if len(args) > 1 {
+			fmt.Printf("unexpected arguments %q, want 'status [-o table|json] [program]'\n", args[1:])
+			os.Exit(1)
+		}
+		program = ""
+		if len(args) == 1 {
+			program = args[0]
+		}
+		err = process.Status(program, *output)
+	case process.ActionReloadConfig:
+		err = process.ReloadConfig()
 	case process.ActionExit:
 		err = process.Exit()
 	default:
-		fmt.Printf("unknown action %q, want one of [start, stop, restart, kill, reload, status, exit]\n", action)
+		fmt.Printf("unknown action %q, want one of [start, stop, restart, kill, reload, reload-config, status, exit]\n", action)
 		os.Exit(1)
 	}
 
@@ -67,3 +92,21 @@ func client() {
 		os.Exit(1)
 	}
 }
+
+// parseFlags parses the flags wherever they are in args, unlike fs.Parse stopping at the first non-flag argument,
+// and returns the non-flag arguments. Those after "--" are all non-flag.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		_ = fs.Parse(args)
+		left := fs.Args()
+		if n := len(args) - len(left); n > 0 && args[n-1] == "--" {
+			return append(rest, left...)
+		}
+		if len(left) == 0 {
+			return rest
+		}
+		rest = append(rest, left[0])
+		args = left[1:]
+	}
+}
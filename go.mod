@@ -1,5 +1,5 @@
 module github.com/sequix/sup
 
-go 1.19
+go 1.20
 
 require github.com/pelletier/go-toml v1.8.1
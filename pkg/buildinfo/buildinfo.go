@@ -27,16 +27,19 @@ func init() {
 
 func printVersionAndHelp() {
 	fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -h                      # show this message\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -v                      # show this message\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml          # start sup daemon\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml start    # start program asynchronously\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml stop     # stop program asynchronously\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml restart  # restart program asynchronously\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml reload   # reload program\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml kill     # kill program and all child processes\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml status   # print status of program\n")
-	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml exit     # exit the sup daemon and the process asynchronously\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -h                             # show this message\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -v                             # show this message\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml                 # start sup daemon\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml start [name]    # start program asynchronously\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml stop [name]     # stop program asynchronously\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml restart [name]  # restart program asynchronously\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml reload [name]   # reload program\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml kill [name]     # kill program and all child processes\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml status [name]   # print status of program as a table\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml status -o json  # print status of program as json\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "sup -c config.toml exit            # exit the sup daemon and the process asynchronously\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "[name] is the program name in [programs], all programs by default or given 'all'.\n")
 	fmt.Fprintf(flag.CommandLine.Output(), "\n")
 	fmt.Fprintf(flag.CommandLine.Output(), "Sup Commit ID: %s\n", Commit)
 	fmt.Fprintf(flag.CommandLine.Output(), "\n")
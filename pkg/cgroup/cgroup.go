@@ -0,0 +1,233 @@
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroup v2 doc: https://docs.kernel.org/admin-guide/cgroup-v2.html
+
+// Cgroup is a cgroup v2 directory.
+type Cgroup struct {
+	mountPoint string
+	path       string
+}
+
+// Stat is the resource usage of the processes in a cgroup and its descendants.
+type Stat struct {
+	Path        string  `json:"path"`
+	MemoryBytes int64   `json:"memoryBytes"`
+	CPUSeconds  float64 `json:"cpuSeconds"`
+	Pids        int     `json:"pids"`
+	// OOMKills is the number of processes killed by the OOM killer for reaching memory.max.
+	OOMKills int `json:"oomKills"`
+}
+
+// MountPoint returns where cgroup v2 is mounted, like /sys/fs/cgroup, or /sys/fs/cgroup/unified on hybrid hosts.
+func MountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("failed to open /proc/self/mountinfo: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 0:30 / /sys/fs/cgroup rw,nosuid - cgroup2 cgroup2 rw
+		line := scanner.Text()
+		i := strings.Index(line, " - ")
+		if i < 0 {
+			continue
+		}
+		fields, tail := strings.Fields(line[:i]), strings.Fields(line[i+3:])
+		if len(fields) >= 5 && len(tail) > 0 && tail[0] == "cgroup2" {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read /proc/self/mountinfo: %s", err)
+	}
+	return "", errors.New("cgroup v2 not mounted")
+}
+
+// New creates the cgroup given by path relative to the cgroup v2 mount point if not existing.
+func New(path string) (*Cgroup, error) {
+	mountPoint, err := MountPoint()
+	if err != nil {
+		return nil, err
+	}
+	cg := &Cgroup{
+		mountPoint: mountPoint,
+		path:       filepath.Join(mountPoint, path),
+	}
+	if err := os.MkdirAll(cg.path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %s", cg.path, err)
+	}
+	return cg, nil
+}
+
+// Path returns the absolute path of the cgroup directory.
+func (cg *Cgroup) Path() string {
+	return cg.path
+}
+
+// EnableControllers enables the controllers like "memory" and "cpu" for the children of the cgroup,
+// and so for those of all its ancestors.
+func (cg *Cgroup) EnableControllers(controllers ...string) error {
+	rel, err := filepath.Rel(cg.mountPoint, cg.path)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path of cgroup %s: %s", cg.path, err)
+	}
+	dir := cg.mountPoint
+	dirs := []string{dir}
+	if rel != "." {
+		for _, name := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, name)
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		ancestor := &Cgroup{mountPoint: cg.mountPoint, path: dir}
+		available, err := ancestor.read("cgroup.controllers")
+		if err != nil {
+			return err
+		}
+		enabled, err := ancestor.read("cgroup.subtree_control")
+		if err != nil {
+			return err
+		}
+		for _, controller := range controllers {
+			if !containsField(available, controller) {
+				return fmt.Errorf("controller %s not available in cgroup %s", controller, dir)
+			}
+			if containsField(enabled, controller) {
+				continue
+			}
+			if err := ancestor.write("cgroup.subtree_control", "+"+controller); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Set writes value to the interface file of the cgroup like memory.max.
+func (cg *Cgroup) Set(file, value string) error {
+	return cg.write(file, value)
+}
+
+// Stat returns the resource usage of the cgroup, zero for those of the controllers not enabled.
+func (cg *Cgroup) Stat() (*Stat, error) {
+	stat := &Stat{Path: cg.path}
+	if content, err := cg.read("memory.current"); err == nil {
+		stat.MemoryBytes, _ = strconv.ParseInt(strings.TrimSpace(content), 10, 64)
+	}
+	if content, err := cg.read("pids.current"); err == nil {
+		stat.Pids, _ = strconv.Atoi(strings.TrimSpace(content))
+	}
+	if content, err := cg.read("memory.events"); err == nil {
+		stat.OOMKills = int(keyedValue(content, "oom_kill"))
+	}
+	content, err := cg.read("cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	stat.CPUSeconds = float64(keyedValue(content, "usage_usec")) / 1e6
+	return stat, nil
+}
+
+// Procs returns the pids of the processes in the cgroup, excluding those in its descendant cgroups.
+func (cg *Cgroup) Procs() ([]int, error) {
+	content, err := cg.read("cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, line := range strings.Fields(content) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in %s", line, filepath.Join(cg.path, "cgroup.procs"))
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// Signal sends sig to every process in the cgroup.
+func (cg *Cgroup) Signal(sig syscall.Signal) error {
+	pids, err := cg.Procs()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed to send signal %d to process %d: %s", sig, pid, err)
+		}
+	}
+	return nil
+}
+
+// Kill kills every process in the cgroup, with cgroup.kill if supported by the kernel (5.14+).
+func (cg *Cgroup) Kill() error {
+	if _, err := os.Stat(filepath.Join(cg.path, "cgroup.kill")); err == nil {
+		return cg.write("cgroup.kill", "1")
+	}
+	return cg.Signal(syscall.SIGKILL)
+}
+
+// Remove removes the cgroup, retrying a few times since the processes just killed may still be leaving.
+func (cg *Cgroup) Remove() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = syscall.Rmdir(cg.path); err == nil || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %s", cg.path, err)
+}
+
+func (cg *Cgroup) read(file string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(cg.path, file))
+	if err != nil {
+		return "", fmt.Errorf("failed to read cgroup file: %s", err)
+	}
+	return string(content), nil
+}
+
+func (cg *Cgroup) write(file, content string) error {
+	if err := os.WriteFile(filepath.Join(cg.path, file), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write cgroup file: %s", err)
+	}
+	return nil
+}
+
+// keyedValue returns the value of key in the flat keyed file like cpu.stat, or 0 if not found.
+func keyedValue(content, key string) int64 {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			v, _ := strconv.ParseInt(fields[1], 10, 64)
+			return v
+		}
+	}
+	return 0
+}
+
+func containsField(content, field string) bool {
+	for _, f := range strings.Fields(content) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
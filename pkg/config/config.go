@@ -2,12 +2,22 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/pelletier/go-toml"
 
 	"github.com/sequix/sup/pkg/log"
+	"github.com/sequix/sup/pkg/rotate"
 )
 
 var (
@@ -18,51 +28,623 @@ var (
 	G = &Config{}
 )
 
+const (
+	// DefaultProgramName is the name of the program configured by [program].
+	DefaultProgramName = "default"
+	// AllPrograms refers to every program in CLI actions.
+	AllPrograms = "all"
+)
+
+var reProgramName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 func Init() {
 	if len(*flagConfigPath) == 0 {
 		log.Fatal("need specify config path with flag -c")
 	}
+	cfg, err := Load(*flagConfigPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	G = cfg
+}
+
+// InitClient reads only the socket of sup from the config, which is all the CLI needs to reach the daemon.
+// The programs are not validated, so no envFiles or secrets are read.
+func InitClient() {
+	if len(*flagConfigPath) == 0 {
+		log.Fatal("need specify config path with flag -c")
+	}
+	socket, err := LoadSocket(*flagConfigPath)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	G = &Config{SupConfig: Sup{Socket: socket}}
+}
 
-	tf, err := toml.LoadFile(*flagConfigPath)
+// Path returns the path of the config given by flag -c.
+func Path() string {
+	return *flagConfigPath
+}
+
+// Load reads and validates the config file.
+func Load(filename string) (*Config, error) {
+	tf, err := toml.LoadFile(filename)
 	if err != nil {
-		log.Fatal("read config %q: %s", *flagConfigPath, err)
+		return nil, fmt.Errorf("read config %q: %s", filename, err)
 	}
 
-	if err := tf.Unmarshal(G); err != nil {
-		log.Fatal("unmarshal config: %s", err)
+	cfg := &Config{}
+	if err := tf.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %s", err)
 	}
 
-	if len(G.ProgramConfig.Process.RestartStrategy) == 0 {
-		G.ProgramConfig.Process.RestartStrategy = RestartStrategyOnFailure
+	// [program] is kept for the configs supervising only one program.
+	if len(cfg.ProgramConfig.Process.Path) > 0 {
+		if _, ok := cfg.Programs[DefaultProgramName]; ok {
+			return nil, fmt.Errorf("program %q defined by both [program] and [programs.%s]", DefaultProgramName, DefaultProgramName)
+		}
+		if cfg.Programs == nil {
+			cfg.Programs = make(map[string]*Program, 1)
+		}
+		cfg.Programs[DefaultProgramName] = &cfg.ProgramConfig
+	}
+	if len(cfg.Programs) == 0 {
+		return nil, fmt.Errorf("expected at least one program in [program] or [programs]")
 	}
 
-	if len(G.ProgramConfig.Process.WorkDir) == 0 {
-		wd, err := os.Getwd()
-		if err != nil {
-			log.Fatal("get working dir: %s", err)
+	for name, program := range cfg.Programs {
+		if name == AllPrograms || !reProgramName.MatchString(name) {
+			return nil, fmt.Errorf("invalid program name %q", name)
+		}
+		program.Name = name
+		if err := initProgram(program); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.SupConfig.Socket, err = socketPath(cfg); err != nil {
+		return nil, err
+	}
+
+	for _, program := range cfg.Programs {
+		if !program.Notify.Enabled {
+			continue
+		}
+		if len(program.Notify.Socket) == 0 {
+			program.Notify.Socket = fmt.Sprintf("%s.%s.notify", cfg.SupConfig.Socket, program.Name)
+		} else if !filepath.IsAbs(program.Notify.Socket) {
+			program.Notify.Socket = filepath.Clean(filepath.Join(filepath.Dir(cfg.SupConfig.Socket), program.Notify.Socket))
+		}
+		if chroot := program.Process.Chroot; len(chroot) > 0 && !strings.HasPrefix(program.Notify.Socket, chroot+"/") {
+			return nil, fmt.Errorf("notify socket %s of program %s is not inside its chroot %s", program.Notify.Socket, program.Name, chroot)
+		}
+	}
+	return cfg, nil
+}
+
+// LoadSocket reads the socket path of sup from the config file, without validating the programs.
+func LoadSocket(filename string) (string, error) {
+	tf, err := toml.LoadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("read config %q: %s", filename, err)
+	}
+	cfg := &Config{}
+	if err := tf.Unmarshal(cfg); err != nil {
+		return "", fmt.Errorf("unmarshal config: %s", err)
+	}
+	return socketPath(cfg)
+}
+
+// socketPath returns the absolute socket path of sup, a relative one is based on the workDir of [program],
+// or the current directory for [programs].
+func socketPath(cfg *Config) (string, error) {
+	socket := cfg.SupConfig.Socket
+	if len(socket) == 0 {
+		return "", fmt.Errorf("expected non-empty socket path")
+	}
+	if filepath.IsAbs(socket) {
+		return socket, nil
+	}
+	baseDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working dir: %s", err)
+	}
+	if process := &cfg.ProgramConfig.Process; len(process.Path) > 0 {
+		if baseDir, err = workDir(process); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Clean(filepath.Join(baseDir, socket)), nil
+}
+
+// workDir returns the workDir of the process, which is / in chroot or the current directory if not given.
+func workDir(p *Process) (string, error) {
+	if len(p.WorkDir) > 0 {
+		return p.WorkDir, nil
+	}
+	if len(p.Chroot) > 0 && filepath.Clean(p.Chroot) != "/" {
+		return "/", nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("get working dir: %s", err)
+	}
+	return wd, nil
+}
+
+func initProgram(program *Program) error {
+	if len(program.Process.RestartStrategy) == 0 {
+		program.Process.RestartStrategy = RestartStrategyOnFailure
+	}
+
+	var err error
+	if program.Process.StopSig, err = ParseSignal(program.Process.StopSignal); err != nil {
+		return fmt.Errorf("invalid stopSignal of program %s: %s", program.Name, err)
+	}
+	if program.Process.StopSig == 0 {
+		return fmt.Errorf("expected a stopSignal for program %s", program.Name)
+	}
+	if program.Process.ReloadSig, err = ParseSignal(program.Process.ReloadSignal); err != nil {
+		return fmt.Errorf("invalid reloadSignal of program %s: %s", program.Name, err)
+	}
+
+	if program.Process.SuccessExitCodes == nil {
+		program.Process.SuccessExitCodes = []int{0}
+	}
+	for _, codes := range [][]int{program.Process.SuccessExitCodes, program.Process.NoRestartExitCodes} {
+		for _, code := range codes {
+			if code < 0 || code > 255 {
+				return fmt.Errorf("invalid exit code %d of program %s, expected in [0, 255]", code, program.Name)
+			}
+		}
+	}
+
+	if err := validateCapabilities(&program.Process.Capabilities); err != nil {
+		return fmt.Errorf("invalid capabilities of program %s: %s", program.Name, err)
+	}
+
+	if err := validateRlimits(&program.Process.Rlimits); err != nil {
+		return fmt.Errorf("invalid rlimits of program %s: %s", program.Name, err)
+	}
+	if err := validateProcessAttrs(&program.Process); err != nil {
+		return fmt.Errorf("invalid process of program %s: %s", program.Name, err)
+	}
+
+	if err := validateLog(&program.Log); err != nil {
+		return fmt.Errorf("invalid log of program %s: %s", program.Name, err)
+	}
+
+	if err := validateBackoff(&program.Process.Backoff); err != nil {
+		return fmt.Errorf("invalid backoff of program %s: %s", program.Name, err)
+	}
+
+	if err := validateHealthCheck(&program.HealthCheck); err != nil {
+		return fmt.Errorf("invalid healthcheck of program %s: %s", program.Name, err)
+	}
+
+	if err := validateReadiness(&program.Readiness, &program.HealthCheck); err != nil {
+		return fmt.Errorf("invalid readiness of program %s: %s", program.Name, err)
+	}
+
+	if err := validateResources(&program.Resources); err != nil {
+		return fmt.Errorf("invalid resources of program %s: %s", program.Name, err)
+	}
+	if len(program.Resources.Controllers()) > 0 {
+		program.Cgroup.Enabled = true
+	}
+
+	if program.Cgroup.Enabled {
+		parent := filepath.Clean("/" + program.Cgroup.Parent)
+		if parent != "/"+strings.Trim(program.Cgroup.Parent, "/") {
+			return fmt.Errorf("invalid cgroup parent %q of program %s", program.Cgroup.Parent, program.Name)
 		}
-		G.ProgramConfig.Process.WorkDir = wd
+		program.Cgroup.Parent = strings.TrimPrefix(parent, "/")
 	}
 
-	if !filepath.IsAbs(G.ProgramConfig.Process.WorkDir) {
-		log.Fatal("expected an absolute path for process workdir")
+	if program.Notify.WatchdogSeconds < 0 {
+		return fmt.Errorf("invalid notify of program %s: expected watchdogSeconds >= 0, got %d", program.Name, program.Notify.WatchdogSeconds)
+	}
+	if program.Readiness.Type == ReadinessNotify || program.Notify.WatchdogSeconds > 0 {
+		program.Notify.Enabled = true
 	}
 
-	if len(G.SupConfig.Socket) == 0 {
-		log.Fatal("expected non-empty socket path")
+	if err := validateSandbox(&program.Process); err != nil {
+		return fmt.Errorf("invalid process of program %s: %s", program.Name, err)
 	}
-	if !filepath.IsAbs(G.SupConfig.Socket) {
-		G.SupConfig.Socket = filepath.Clean(filepath.Join(G.ProgramConfig.Process.WorkDir, G.SupConfig.Socket))
+	if program.Process.Cloneflags&syscall.CLONE_NEWNET != 0 &&
+		(program.HealthCheck.Type == HealthCheckHTTP || program.HealthCheck.Type == HealthCheckTCP) {
+		return fmt.Errorf("%s healthcheck of program %s cannot reach the process in a new net namespace", program.HealthCheck.Type, program.Name)
 	}
 
-	if !filepath.IsAbs(G.ProgramConfig.Process.Path) {
-		G.ProgramConfig.Process.Path = filepath.Clean(filepath.Join(G.ProgramConfig.Process.WorkDir, G.ProgramConfig.Process.Path))
+	if program.Process.WorkDir, err = workDir(&program.Process); err != nil {
+		return err
 	}
-	stat, err := os.Stat(G.ProgramConfig.Process.Path)
+
+	if !filepath.IsAbs(program.Process.WorkDir) {
+		return fmt.Errorf("expected an absolute path for process workdir of program %s", program.Name)
+	}
+
+	for i, envFile := range program.Process.EnvFiles {
+		if !filepath.IsAbs(envFile) {
+			program.Process.EnvFiles[i] = filepath.Join(program.Process.Chroot, program.Process.WorkDir, envFile)
+		}
+		if _, err := ReadEnvFile(program.Process.EnvFiles[i]); err != nil {
+			return fmt.Errorf("invalid envFiles of program %s: %s", program.Name, err)
+		}
+	}
+	for name, value := range program.Process.Envs {
+		if value.Secret() && !filepath.IsAbs(value.File) {
+			value.File = filepath.Join(program.Process.Chroot, program.Process.WorkDir, value.File)
+			program.Process.Envs[name] = value
+		}
+	}
+	for _, pattern := range append(program.Process.InheritEnvAllow, program.Process.InheritEnvDeny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid inheritEnv pattern %q of program %s: %s", pattern, program.Name, err)
+		}
+	}
+
+	if len(program.Readiness.File) > 0 && !filepath.IsAbs(program.Readiness.File) {
+		program.Readiness.File = filepath.Clean(filepath.Join(program.Process.WorkDir, program.Readiness.File))
+	}
+
+	if !filepath.IsAbs(program.Process.Path) {
+		program.Process.Path = filepath.Clean(filepath.Join(program.Process.WorkDir, program.Process.Path))
+	}
+	hostPath := filepath.Join(program.Process.Chroot, program.Process.Path)
+	stat, err := os.Stat(hostPath)
 	if err != nil {
-		log.Fatal("failed to stat program file %s: %s", G.ProgramConfig.Process.Path, err)
+		return fmt.Errorf("failed to stat program file %s: %s", hostPath, err)
 	}
 	if (stat.Mode() & 0111) == 0 {
-		log.Fatal("program file is not executable: %s", G.ProgramConfig.Process.Path)
+		return fmt.Errorf("program file is not executable: %s", hostPath)
+	}
+	return nil
+}
+
+func validateBackoff(b *Backoff) error {
+	if b.InitialSeconds < 0 {
+		return fmt.Errorf("expected initialSeconds >= 0, got %d", b.InitialSeconds)
+	}
+	if b.Multiplier < 1 {
+		return fmt.Errorf("expected multiplier >= 1, got %d", b.Multiplier)
+	}
+	if b.MaxSeconds < b.InitialSeconds {
+		return fmt.Errorf("expected maxSeconds >= initialSeconds, got %d", b.MaxSeconds)
+	}
+	if b.MaxRetries < 0 {
+		return fmt.Errorf("expected maxRetries >= 0, got %d", b.MaxRetries)
+	}
+	if b.MaxRetries > 0 && b.WindowSeconds <= 0 {
+		return fmt.Errorf("expected windowSeconds > 0, got %d", b.WindowSeconds)
+	}
+	return nil
+}
+
+func validateLog(l *Log) error {
+	files := []struct {
+		name string
+		file LogFile
+	}{
+		{"", LogFile{Path: l.Path, MaxSize: l.MaxSize, MergeCompressed: l.MergeCompressed, RotateSchedule: l.RotateSchedule}},
+		{"stdout.", l.Stdout},
+		{"stderr.", l.Stderr},
+	}
+	for _, f := range files {
+		if err := validateLogFile(&f.file); err != nil {
+			return fmt.Errorf("invalid %s%s", f.name, err)
+		}
+	}
+	if l.Format != LogFormatText && l.Format != LogFormatJSON {
+		return fmt.Errorf("unknown format %q, want one of [%s, %s]", l.Format, LogFormatText, LogFormatJSON)
+	}
+	if strings.ContainsAny(l.TimeFormat+l.StderrTag, "\r\n") {
+		return fmt.Errorf("expected timeFormat and stderrTag in a single line")
+	}
+	paths := map[string]string{}
+	for _, f := range []struct{ name, path string }{{"path", l.Path}, {"stdout.path", l.Stdout.Path}, {"stderr.path", l.Stderr.Path}} {
+		if len(f.path) == 0 {
+			continue
+		}
+		if name, ok := paths[filepath.Clean(f.path)]; ok {
+			return fmt.Errorf("expected different %s and %s, got %q", name, f.name, f.path)
+		}
+		paths[filepath.Clean(f.path)] = f.name
+	}
+	return nil
+}
+
+func validateLogFile(f *LogFile) error {
+	if len(f.RotateSchedule) == 0 {
+		if f.MaxSize <= 0 {
+			return fmt.Errorf("maxSize: expected maxSize > 0 without rotateSchedule, got %d", f.MaxSize)
+		}
+		return nil
+	}
+	if _, err := rotate.ParseSchedule(f.RotateSchedule); err != nil {
+		return fmt.Errorf("rotateSchedule: %s", err)
+	}
+	if f.MaxSize < 0 {
+		return fmt.Errorf("maxSize: expected maxSize >= 0, got %d", f.MaxSize)
+	}
+	if f.MergeCompressed {
+		return fmt.Errorf("mergeCompressed: cannot merge the backups rotated by rotateSchedule")
+	}
+	return nil
+}
+
+func validateSandbox(p *Process) error {
+	p.Cloneflags = 0
+	for _, name := range p.Namespaces {
+		flag, ok := namespaces[name]
+		if !ok {
+			return fmt.Errorf("unknown namespace %q, want any of [mount, pid, ipc, uts, net]", name)
+		}
+		p.Cloneflags |= flag
+	}
+	if p.Cloneflags&syscall.CLONE_NEWPID != 0 || p.PrivateTmp || len(p.ReadOnlyPaths) > 0 {
+		p.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	for _, path := range p.ReadOnlyPaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("expected absolute path in readOnlyPaths, got %q", path)
+		}
+	}
+	if len(p.Chroot) > 0 {
+		if !filepath.IsAbs(p.Chroot) {
+			return fmt.Errorf("expected absolute path for chroot, got %q", p.Chroot)
+		}
+		p.Chroot = filepath.Clean(p.Chroot)
+		if p.Chroot == "/" {
+			p.Chroot = ""
+			return nil
+		}
+		stat, err := os.Stat(p.Chroot)
+		if err != nil {
+			return fmt.Errorf("failed to stat chroot: %s", err)
+		}
+		if !stat.IsDir() {
+			return fmt.Errorf("chroot is not a directory: %s", p.Chroot)
+		}
+	}
+	return nil
+}
+
+func validateCapabilities(c *Capabilities) error {
+	var err error
+	if c.AmbientCaps, err = parseCapabilities(c.Ambient); err != nil {
+		return fmt.Errorf("invalid ambient: %s", err)
+	}
+	if c.BoundingCaps, err = parseCapabilities(c.Bounding); err != nil {
+		return fmt.Errorf("invalid bounding: %s", err)
+	}
+	if c.BoundingCaps == nil {
+		return nil
+	}
+	for i, ambient := range c.AmbientCaps {
+		found := false
+		for _, bounding := range c.BoundingCaps {
+			found = found || bounding == ambient
+		}
+		if !found {
+			return fmt.Errorf("ambient capability %s not in bounding", c.Ambient[i])
+		}
+	}
+	return nil
+}
+
+func validateRlimits(r *Rlimits) error {
+	r.Parsed = nil
+	for _, l := range []struct {
+		name     string
+		resource int
+		value    string
+		size     bool
+	}{
+		{"nofile", RlimitNoFile, r.NoFile, false},
+		{"core", RlimitCore, r.Core, true},
+		{"nproc", RlimitNProc, r.NProc, false},
+		{"as", RlimitAS, r.AS, true},
+	} {
+		if len(l.value) == 0 {
+			continue
+		}
+		soft, hard := l.value, l.value
+		if i := strings.Index(l.value, ":"); i >= 0 {
+			soft, hard = l.value[:i], l.value[i+1:]
+		}
+		cur, err := parseRlimit(soft, l.size)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", l.name, err)
+		}
+		max, err := parseRlimit(hard, l.size)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", l.name, err)
+		}
+		if cur > max {
+			return fmt.Errorf("expected soft %s <= hard, got %q", l.name, l.value)
+		}
+		r.Parsed = append(r.Parsed, Rlimit{Name: l.name, Resource: l.resource, Cur: cur, Max: max})
+	}
+	return nil
+}
+
+// parseRlimit parses a limit, which is a number, 'unlimited', or a size like '1G' if size is true.
+func parseRlimit(s string, size bool) (uint64, error) {
+	switch {
+	case s == "unlimited" || s == "infinity":
+		return RlimitInfinity, nil
+	case s == "0":
+		return 0, nil
+	case size:
+		n, err := parseBytes(s)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("expected non-empty limit")
+		}
+		return uint64(n), nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == RlimitInfinity {
+		return 0, fmt.Errorf("expected a number or 'unlimited', got %q", s)
+	}
+	return n, nil
+}
+
+func validateProcessAttrs(p *Process) error {
+	if p.Nice < -20 || p.Nice > 19 {
+		return fmt.Errorf("expected nice in [-20, 19], got %d", p.Nice)
+	}
+	if _, ok := IOClasses[p.IOClass]; !ok && p.IOClass != IOClassNone {
+		return fmt.Errorf("unknown ioClass %q, want one of [%s, %s, %s]", p.IOClass, IOClassRealtime, IOClassBestEffort, IOClassIdle)
+	}
+	if p.IOPriority < 0 || p.IOPriority > 7 {
+		return fmt.Errorf("expected ioPriority in [0, 7], got %d", p.IOPriority)
+	}
+	p.UmaskBits = -1
+	if len(p.Umask) > 0 {
+		umask, err := strconv.ParseUint(p.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return fmt.Errorf("expected umask in octal like '0022', got %q", p.Umask)
+		}
+		p.UmaskBits = int(umask)
+	}
+	if p.OOMScoreAdj < -1000 || p.OOMScoreAdj > 1000 {
+		return fmt.Errorf("expected oomScoreAdj in [-1000, 1000], got %d", p.OOMScoreAdj)
+	}
+	return nil
+}
+
+func validateHealthCheck(h *HealthCheck) error {
+	switch h.Type {
+	case HealthCheckNone:
+		return nil
+	case HealthCheckHTTP:
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url %q: %s", h.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("expected url with scheme http or https, got %q", h.URL)
+		}
+		if h.ExpectStatus < 100 || h.ExpectStatus > 599 {
+			return fmt.Errorf("invalid expectStatus %d", h.ExpectStatus)
+		}
+		if _, err := regexp.Compile(h.BodyMatch); err != nil {
+			return fmt.Errorf("invalid bodyMatch %q: %s", h.BodyMatch, err)
+		}
+	case HealthCheckTCP:
+		if _, _, err := net.SplitHostPort(h.Address); err != nil {
+			return fmt.Errorf("invalid address %q: %s", h.Address, err)
+		}
+	case HealthCheckExec:
+		if len(h.Command) == 0 {
+			return fmt.Errorf("expected non-empty command")
+		}
+	default:
+		return fmt.Errorf("unknown type %q, want one of [%s, %s, %s]", h.Type, HealthCheckHTTP, HealthCheckTCP, HealthCheckExec)
+	}
+	if h.IntervalSeconds <= 0 {
+		return fmt.Errorf("expected intervalSeconds > 0, got %d", h.IntervalSeconds)
+	}
+	if h.TimeoutSeconds <= 0 {
+		return fmt.Errorf("expected timeoutSeconds > 0, got %d", h.TimeoutSeconds)
+	}
+	if h.FailureThreshold <= 0 {
+		return fmt.Errorf("expected failureThreshold > 0, got %d", h.FailureThreshold)
+	}
+	if h.StartPeriodSeconds < 0 {
+		return fmt.Errorf("expected startPeriodSeconds >= 0, got %d", h.StartPeriodSeconds)
+	}
+	return nil
+}
+
+func validateReadiness(r *Readiness, h *HealthCheck) error {
+	switch r.Type {
+	case ReadinessNone:
+	case ReadinessProbe:
+		if h.Type == HealthCheckNone {
+			return fmt.Errorf("expected a healthcheck type for 'probe' readiness")
+		}
+	case ReadinessLog:
+		if len(r.LogMatch) == 0 {
+			return fmt.Errorf("expected non-empty logMatch")
+		}
+		if _, err := regexp.Compile(r.LogMatch); err != nil {
+			return fmt.Errorf("invalid logMatch %q: %s", r.LogMatch, err)
+		}
+	case ReadinessFile:
+		if len(r.File) == 0 {
+			return fmt.Errorf("expected non-empty file")
+		}
+	case ReadinessNotify:
+	default:
+		return fmt.Errorf("unknown type %q, want one of [%s, %s, %s, %s]", r.Type, ReadinessProbe, ReadinessLog, ReadinessFile, ReadinessNotify)
+	}
+	return nil
+}
+
+func validateResources(r *Resources) error {
+	var err error
+	if r.MemoryMaxBytes, err = parseBytes(r.MemoryMax); err != nil {
+		return fmt.Errorf("invalid memoryMax: %s", err)
+	}
+	if r.MemoryHighBytes, err = parseBytes(r.MemoryHigh); err != nil {
+		return fmt.Errorf("invalid memoryHigh: %s", err)
+	}
+	if len(r.CPUMax) > 0 {
+		fields := strings.Fields(r.CPUMax)
+		if len(fields) != 2 {
+			return fmt.Errorf("expected cpuMax like '<quota> <period>', got %q", r.CPUMax)
+		}
+		quota, err1 := strconv.Atoi(fields[0])
+		period, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || quota < 1000 || period < 1000 || period > 1000000 {
+			return fmt.Errorf("expected cpuMax quota >= 1000 and period in [1000, 1000000], got %q", r.CPUMax)
+		}
+	}
+	if r.PidsMax < 0 {
+		return fmt.Errorf("expected pidsMax >= 0, got %d", r.PidsMax)
+	}
+	if r.IOWeight < 0 || r.IOWeight > 10000 {
+		return fmt.Errorf("expected ioWeight in [1, 10000], got %d", r.IOWeight)
+	}
+	return nil
+}
+
+var reBytes = regexp.MustCompile(`^([0-9]+)([KMGT]?)$`)
+
+// parseBytes parses size like "512M" with binary unit suffix K, M, G or T, empty is parsed to 0.
+func parseBytes(s string) (int64, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	m := reBytes.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil {
+		return 0, fmt.Errorf("expected size like '512M', got %q", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %s", s, err)
+	}
+	shift := 0
+	if len(m[2]) > 0 {
+		shift = 10 * (strings.Index("KMGT", m[2]) + 1)
+	}
+	if n <= 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return n << shift, nil
+}
+
+// ProgramNames returns the names of all programs in order.
+func (c *Config) ProgramNames() []string {
+	names := make([]string, 0, len(c.Programs))
+	for name := range c.Programs {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
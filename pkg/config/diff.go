@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff returns the changes from old to new program config like "process.args: [-v] -> [-vv]", keyed by the toml
+// names of the fields, in the order of the fields. The fields parsed from others are not compared, and a secret
+// env is shown redacted.
+func Diff(old, new *Program) []string {
+	var changes []string
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+	return changes
+}
+
+func diffValue(key string, old, new reflect.Value, changes *[]string) {
+	_, stringer := old.Interface().(fmt.Stringer)
+	switch {
+	case old.Kind() == reflect.Struct && !stringer:
+		for i := 0; i < old.NumField(); i++ {
+			name := strings.Split(old.Type().Field(i).Tag.Get("toml"), ",")[0]
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			if len(key) > 0 {
+				name = key + "." + name
+			}
+			diffValue(name, old.Field(i), new.Field(i), changes)
+		}
+	case old.Kind() == reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range append(old.MapKeys(), new.MapKeys()...) {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			oldElem, newElem := old.MapIndex(keys[name]), new.MapIndex(keys[name])
+			switch {
+			case !oldElem.IsValid():
+				*changes = append(*changes, fmt.Sprintf("%s.%s: added %v", key, name, newElem.Interface()))
+			case !newElem.IsValid():
+				*changes = append(*changes, fmt.Sprintf("%s.%s: removed", key, name))
+			default:
+				diffValue(key+"."+name, oldElem, newElem, changes)
+			}
+		}
+	default:
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			*changes = append(*changes, fmt.Sprintf("%s: %v -> %v", key, old.Interface(), new.Interface()))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Env is a variable in an env file.
+type Env struct {
+	Name  string
+	Value string
+	// Literal is true if the value is single-quoted, in which ${VAR} is not expanded.
+	Literal bool
+}
+
+var reEnvName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ReadEnvFile reads the variables in a dotenv file in order, which has 'NAME=value' per line, optionally prefixed
+// by 'export '. Blank lines and lines starting with '#' are ignored. The value could be in single quotes taken
+// literally, or in double quotes in which \n, \t, \" and \\ are escaped. A value not quoted ends before ' #'.
+func ReadEnvFile(filename string) ([]Env, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %s", err)
+	}
+	defer f.Close()
+	var (
+		envs    []Env
+		scanner = bufio.NewScanner(f)
+		lineNum = 0
+	)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env, err := parseEnvLine(strings.TrimPrefix(line, "export "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d of env file %s: %s", lineNum, filename, err)
+		}
+		envs = append(envs, env)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file %s: %s", filename, err)
+	}
+	return envs, nil
+}
+
+func parseEnvLine(line string) (Env, error) {
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return Env{}, fmt.Errorf("expected 'NAME=value', got %q", line)
+	}
+	env := Env{Name: strings.TrimSpace(line[:i])}
+	if !reEnvName.MatchString(env.Name) {
+		return Env{}, fmt.Errorf("invalid name %q", env.Name)
+	}
+	value := strings.TrimSpace(line[i+1:])
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return Env{}, fmt.Errorf("unterminated single quote in %q", value)
+		}
+		env.Value, env.Literal = value[1:end+1], true
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		closed := false
+		for j := 1; j < len(value) && !closed; j++ {
+			switch c := value[j]; {
+			case c == '"':
+				closed = true
+			case c == '\\' && j+1 < len(value):
+				j++
+				switch value[j] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[j])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		if !closed {
+			return Env{}, fmt.Errorf("unterminated double quote in %q", value)
+		}
+		env.Value = b.String()
+	default:
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimSpace(value[:j])
+		}
+		env.Value = value
+	}
+	return env, nil
+}
+
+// ExpandEnv replaces ${VAR} in s with the value returned by lookup, and $${ with ${.
+func ExpandEnv(s string, lookup func(name string) string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.Index(s[i:], "}")
+		if end < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		b.WriteString(lookup(s[i+2 : i+end]))
+		s = s[i+end+1:]
+	}
+}
+
+// MatchEnvName reports whether the env name matches any of the patterns like 'AWS_*'.
+func MatchEnvName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvValue is the value of an env in envs, given by a string, or by a secret file like { file = "/run/secrets/db" }.
+type EnvValue struct {
+	Value string
+	// File is read on each start as the value, which is a secret never logged or shown.
+	File string
+	// FD passes the file to the process as an open file descriptor, whose number is given by the env instead.
+	FD bool
+}
+
+// redacted is shown instead of the value of a secret.
+const redacted = "<redacted>"
+
+// Secret reports whether the value is read from a file.
+func (v EnvValue) Secret() bool {
+	return len(v.File) > 0
+}
+
+// String returns the value, or the redacted file for a secret.
+func (v EnvValue) String() string {
+	if v.Secret() {
+		return fmt.Sprintf("%s (file %s, fd %t)", redacted, v.File, v.FD)
+	}
+	return v.Value
+}
+
+// UnmarshalText unmarshals a plain value.
+func (v *EnvValue) UnmarshalText(text []byte) error {
+	*v = EnvValue{Value: string(text)}
+	return nil
+}
+
+// UnmarshalTOML unmarshals a secret file given by a table.
+func (v *EnvValue) UnmarshalTOML(value interface{}) error {
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a string or a table like { file = '/run/secrets/db' }, got %T", value)
+	}
+	*v = EnvValue{}
+	for key, val := range table {
+		switch key {
+		case "file":
+			if v.File, ok = val.(string); !ok || len(v.File) == 0 {
+				return fmt.Errorf("expected a non-empty string for file, got %v", val)
+			}
+		case "fd":
+			if v.FD, ok = val.(bool); !ok {
+				return fmt.Errorf("expected a bool for fd, got %v", val)
+			}
+		default:
+			return fmt.Errorf("unknown key %q, want one of [file, fd]", key)
+		}
+	}
+	if len(v.File) == 0 {
+		return fmt.Errorf("expected file of the secret")
+	}
+	return nil
+}
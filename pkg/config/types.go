@@ -1,29 +1,138 @@
 package config
 
+import "syscall"
+
 type Config struct {
-	SupConfig     Sup     `toml:"sup" comment:"Config related with Sup."`
-	ProgramConfig Program `toml:"program" comment:"Config related with the supervised process."`
+	SupConfig     Sup                 `toml:"sup" comment:"Config related with Sup."`
+	ProgramConfig Program             `toml:"program" comment:"Config related with the supervised process, when there is only one."`
+	Programs      map[string]*Program `toml:"programs" comment:"Config related with the supervised processes, keyed by program name."`
 }
 
 type Sup struct {
-	Socket string `toml:"socket" comment:"Path to an unix socket, to which Sup daemon will be listening. Relative path would based on process.workDir." default:"./sup.sock"`
+	Socket string `toml:"socket" comment:"Path to an unix socket, to which Sup daemon will be listening. Relative path would based on process.workDir of [program], or the current directory for [programs]." default:"./sup.sock"`
 }
 
 type Program struct {
-	Process Process `toml:"process" comment:"Config related with process."`
-	Log     Log     `toml:"log" comment:"Config related with log."`
+	// Name is the key of the program in [programs], or DefaultProgramName for [program].
+	Name        string      `toml:"-"`
+	Process     Process     `toml:"process" comment:"Config related with process."`
+	Log         Log         `toml:"log" comment:"Config related with log."`
+	HealthCheck HealthCheck `toml:"healthcheck" comment:"Config related with health check."`
+	Readiness   Readiness   `toml:"readiness" comment:"Config related with readiness, which confirms the process started."`
+	Notify      Notify      `toml:"notify" comment:"Config related with sd_notify protocol."`
+	Cgroup      Cgroup      `toml:"cgroup" comment:"Config related with cgroup."`
+	Resources   Resources   `toml:"resources" comment:"Config related with resource limits through cgroup."`
 }
 
 type Process struct {
-	Path            string                 `toml:"path" comment:"Path to an executable, which would spawn the supervised process."`
-	Args            []string               `toml:"args" comment:"Arguments to the supervised process."`
-	Envs            map[string]string      `toml:"envs" comment:"Environment variables to the supervised process."`
-	WorkDir         string                 `toml:"workDir" comment:"Working directory of the supervised process given by absolute path. Current directory by default." default:""`
-	AutoStart       bool                   `toml:"autoStart" comment:"Start the process as Sup goes up. False by default." default:"false"`
-	StartSeconds    int                    `toml:"startSeconds" comment:"Sup waits 'startSeconds' after each start to avoid the process restarts too rapidly." default:"5"`
-	RestartStrategy ProcessRestartStrategy `toml:"restartStrategy" comment:"How to react when the supervised process went down. One of 'on-failure', 'always', 'none'. 'on-failure' by default." default:"on-failure"`
-	User            string                 `toml:"user" comment:"User of the supervised process. Inherited from sup by default." default:""`
-	Group           string                 `toml:"group" comment:"Group of the supervised process. Inherited from sup by default." default:""`
+	Path               string                 `toml:"path" comment:"Path to an executable, which would spawn the supervised process."`
+	Args               []string               `toml:"args" comment:"Arguments to the supervised process."`
+	Envs               map[string]EnvValue    `toml:"envs" comment:"Environment variables to the supervised process, overriding those in envFiles. ${VAR} in values is expanded, $${ for a literal ${. A secret is read from a file like { file = '/run/secrets/db' } on each start."`
+	EnvFiles           []string               `toml:"envFiles" comment:"Paths of dotenv files of 'NAME=value' lines read on each start, the latter overriding the former. Relative path would based on workDir."`
+	InheritEnv         bool                   `toml:"inheritEnv" comment:"Pass the environment variables of Sup to the supervised process. True by default." default:"true"`
+	InheritEnvAllow    []string               `toml:"inheritEnvAllow" comment:"Names or patterns like 'LC_*' of the environment variables of Sup passed, all by default."`
+	InheritEnvDeny     []string               `toml:"inheritEnvDeny" comment:"Names or patterns like 'AWS_*' of the environment variables of Sup not passed, none by default."`
+	WorkDir            string                 `toml:"workDir" comment:"Working directory of the supervised process given by absolute path. Current directory by default." default:""`
+	AutoStart          bool                   `toml:"autoStart" comment:"Start the process as Sup goes up. False by default." default:"false"`
+	StartSeconds       int                    `toml:"startSeconds" comment:"Maximum seconds Sup waits for the process to be ready after each start, or seconds the process has to keep running without readiness configured. 0 to wait forever with readiness configured. 5 by default." default:"5"`
+	StopTimeout        int                    `toml:"stopTimeout" comment:"Seconds Sup waits for the process to exit after stopSignal, before sending SIGKILL to it and all its child processes. 0 to wait forever. 10 by default." default:"10"`
+	StopSignal         string                 `toml:"stopSignal" comment:"Signal sent to the process and all its child processes to stop them, given by name like 'QUIT' or number. 'TERM' by default." default:"TERM"`
+	ReloadSignal       string                 `toml:"reloadSignal" comment:"Signal sent to the process to reload it, given by name like 'USR2' or number, or 'none' if it cannot be reloaded. 'HUP' by default." default:"HUP"`
+	RestartStrategy    ProcessRestartStrategy `toml:"restartStrategy" comment:"How to react when the supervised process went down. One of 'on-failure', 'always', 'none'. 'on-failure' by default." default:"on-failure"`
+	SuccessExitCodes   []int                  `toml:"successExitCodes" comment:"Exit codes treated as success by 'on-failure' restartStrategy. [0] by default."`
+	NoRestartExitCodes []int                  `toml:"noRestartExitCodes" comment:"Exit codes after which the process is never restarted automatically, whatever the restartStrategy is."`
+	SignalIsFailure    bool                   `toml:"signalIsFailure" comment:"Whether the process killed by a signal is treated as failure by 'on-failure' restartStrategy. True by default." default:"true"`
+	Backoff            Backoff                `toml:"backoff" comment:"How to delay the automatic restarts of the supervised process."`
+	User               string                 `toml:"user" comment:"User of the supervised process, whose HOME, USER, LOGNAME and SHELL are passed unless given by envs. Inherited from sup by default." default:""`
+	Group              string                 `toml:"group" comment:"Group of the supervised process. The primary group of user if given, otherwise inherited from sup by default." default:""`
+	Groups             []string               `toml:"groups" comment:"Supplementary groups of the supervised process by name or gid, [] for none. Those of user in /etc/group if given, otherwise inherited from sup by default."`
+	Capabilities       Capabilities           `toml:"capabilities" comment:"Linux capabilities of the supervised process."`
+	NoNewPrivileges    bool                   `toml:"noNewPrivileges" comment:"Forbid the supervised process and its descendants to gain privileges by executing setuid, setgid or file capabilities binaries. False by default." default:"false"`
+	Namespaces         []string               `toml:"namespaces" comment:"New namespaces the process is started in. Any of 'mount', 'pid', 'ipc', 'uts', 'net'. 'pid' implies 'mount' to mount its own /proc. None by default."`
+	PrivateTmp         bool                   `toml:"privateTmp" comment:"Mount an empty tmpfs on /tmp of the process. Implies 'mount' namespace. False by default." default:"false"`
+	ReadOnlyPaths      []string               `toml:"readOnlyPaths" comment:"Absolute paths bind mounted read-only for the process. Implies 'mount' namespace."`
+	Chroot             string                 `toml:"chroot" comment:"Absolute path of the root directory of the process, in which path and workDir are resolved. workDir is '/' by default with chroot. Not changed by default." default:""`
+	Rlimits            Rlimits                `toml:"rlimits" comment:"Resource limits of the supervised process set by setrlimit."`
+	Nice               int                    `toml:"nice" comment:"Nice level of the supervised process in [-20, 19]. 0 to inherit from Sup. 0 by default." default:"0"`
+	IOClass            IOClass                `toml:"ioClass" comment:"IO scheduling class of the supervised process. One of 'realtime', 'best-effort', 'idle', or empty to inherit from Sup. Empty by default." default:""`
+	IOPriority         int                    `toml:"ioPriority" comment:"IO scheduling priority in [0, 7] of 'realtime' and 'best-effort' ioClass, 0 is the highest. 4 by default." default:"4"`
+	Umask              string                 `toml:"umask" comment:"File mode creation mask of the supervised process in octal like '0027'. Inherited from Sup by default." default:""`
+	OOMScoreAdj        int                    `toml:"oomScoreAdj" comment:"Adjustment of the OOM killer score in [-1000, 1000], 1000 to be killed first and -1000 never. 0 to inherit from Sup. 0 by default." default:"0"`
+
+	// StopSig and ReloadSig are parsed from StopSignal and ReloadSignal, ReloadSig is 0 if disabled.
+	StopSig   syscall.Signal `toml:"-"`
+	ReloadSig syscall.Signal `toml:"-"`
+	// UmaskBits is parsed from Umask, -1 if inherited.
+	UmaskBits int `toml:"-"`
+	// Cloneflags are the CLONE_NEW* flags parsed from Namespaces and those implied.
+	Cloneflags uintptr `toml:"-"`
+}
+
+// namespaces are the clone flags of the namespaces by name.
+var namespaces = map[string]uintptr{
+	"mount": syscall.CLONE_NEWNS,
+	"pid":   syscall.CLONE_NEWPID,
+	"ipc":   syscall.CLONE_NEWIPC,
+	"uts":   syscall.CLONE_NEWUTS,
+	"net":   syscall.CLONE_NEWNET,
+}
+
+// Capabilities are given by name like 'NET_BIND_SERVICE' or 'CAP_NET_BIND_SERVICE'.
+type Capabilities struct {
+	Ambient  []string `toml:"ambient" comment:"Capabilities kept by the process running as non-root user, and passed to the programs it executes. None by default."`
+	Bounding []string `toml:"bounding" comment:"Capabilities the process and its descendants could ever gain, the others are dropped. Those of Sup by default."`
+
+	// AmbientCaps and BoundingCaps are parsed from Ambient and Bounding, BoundingCaps is nil if not given.
+	AmbientCaps  []uintptr `toml:"-"`
+	BoundingCaps []uintptr `toml:"-"`
+}
+
+// Rlimits are given as '<soft>:<hard>' or a single value for both, each a number or 'unlimited'.
+type Rlimits struct {
+	NoFile string `toml:"nofile" comment:"Maximum number of open files like '65536' or '1024:65536'. Inherited from Sup by default."`
+	Core   string `toml:"core" comment:"Maximum size of core dump files like '0' to disable, '1G' or 'unlimited'. Inherited from Sup by default."`
+	NProc  string `toml:"nproc" comment:"Maximum number of processes and threads of the user like '4096'. Inherited from Sup by default."`
+	AS     string `toml:"as" comment:"Maximum size of virtual memory like '4G' or 'unlimited'. Inherited from Sup by default."`
+
+	// Parsed from the above in order, those inherited are excluded.
+	Parsed []Rlimit `toml:"-"`
+}
+
+// Rlimit is a parsed resource limit.
+type Rlimit struct {
+	// Name like 'nofile' in config.
+	Name     string
+	Resource int
+	Cur      uint64
+	Max      uint64
+}
+
+// RlimitInfinity is RLIM_INFINITY, given by 'unlimited'.
+const RlimitInfinity = ^uint64(0)
+
+// resources of setrlimit, RLIMIT_NPROC is not defined by package syscall.
+const (
+	RlimitCore   = syscall.RLIMIT_CORE
+	RlimitNoFile = syscall.RLIMIT_NOFILE
+	RlimitNProc  = 0x6
+	RlimitAS     = syscall.RLIMIT_AS
+)
+
+// IOClass is the IO scheduling class of ioprio_set.
+type IOClass string
+
+const (
+	IOClassNone       IOClass = ""
+	IOClassRealtime   IOClass = "realtime"
+	IOClassBestEffort IOClass = "best-effort"
+	IOClassIdle       IOClass = "idle"
+)
+
+// IOClasses are the values of the IO scheduling classes in ioprio_set.
+var IOClasses = map[IOClass]int{
+	IOClassRealtime:   1,
+	IOClassBestEffort: 2,
+	IOClassIdle:       3,
 }
 
 // ProcessRestartStrategy how to react when the supervised process went down.
@@ -35,11 +144,135 @@ const (
 	RestartStrategyNone      ProcessRestartStrategy = "none"
 )
 
+// Backoff how to delay the automatic restarts of the supervised process.
+type Backoff struct {
+	InitialSeconds int `toml:"initialSeconds" comment:"Seconds to wait before the first automatic restart. 1 by default." default:"1"`
+	Multiplier     int `toml:"multiplier" comment:"Integer multiplier applied to the delay after each automatic restart. 2 by default." default:"2"`
+	MaxSeconds     int `toml:"maxSeconds" comment:"Maximum seconds to wait before an automatic restart. 60 by default." default:"60"`
+	MaxRetries     int `toml:"maxRetries" comment:"Maximum automatic restarts within 'windowSeconds', after which Sup gives up and the process enters Fatal state until started by CLI. 0 for unlimited. 0 by default." default:"0"`
+	WindowSeconds  int `toml:"windowSeconds" comment:"Seconds of the window in which 'maxRetries' is counted. 300 by default." default:"300"`
+	ResetSeconds   int `toml:"resetSeconds" comment:"Seconds the process has to stay up, for the delay and the restarts counted to be reset. 60 by default." default:"60"`
+}
+
 type Log struct {
-	Path            string `toml:"path" comment:"Path where to save the current un-rotated log. Using basename of the supervised process by default."`
-	MaxSize         int    `toml:"maxSize" comment:"Maximum size in MiB of the log file before it gets rotated. 128 MiB by default." default:"134217728"`
+	Path            string    `toml:"path" comment:"Path where to save the current un-rotated log. Using basename of the supervised process by default."`
+	MaxSize         int       `toml:"maxSize" comment:"Maximum size in MiB of the log file before it gets rotated, 0 to rotate by rotateSchedule only. 128 MiB by default." default:"134217728"`
+	MaxDays         int       `toml:"maxDays" comment:"Maximum days to retain old log files based on the UTC time encoded in their filename. unlimited by default." default:"0"`
+	MaxBackups      int       `toml:"maxBackups" comment:"Maximum number of old log files to retain. Retaining all old log files by default. 32 by default." default:"32"`
+	Compress        bool      `toml:"compress" comment:"Whether the rotated log files should be compressed with gzip, no compression by default." default:"false"`
+	MergeCompressed bool      `toml:"mergeCompressed" comment:"Whether the gzipped backups should be merged, no by default." default:"false"`
+	RotateSchedule  string    `toml:"rotateSchedule" comment:"When to rotate the log file in local time besides maxSize, even if the process is idle. One of 'hourly', 'hourly :MM', 'daily', 'daily HH:MM', 'weekly', 'weekly DAY HH:MM' like 'weekly mon 03:00'. The empty log file is not rotated. Not rotated on schedule by default."`
+	Format          LogFormat `toml:"format" comment:"Format of the log of the process output. 'text' as is, or 'json' wrapping each line like {\"ts\":..., \"stream\":\"stdout\", \"pid\":..., \"program\":..., \"msg\":...}, into which a line of JSON object is merged. 'text' by default." default:"text"`
+	TimeFormat      string    `toml:"timeFormat" comment:"Go time layout like '2006-01-02T15:04:05.000Z07:00' of the time each line of the process output is prefixed with, or of ts in 'json' format. Not prefixed by default, and RFC 3339 for 'json' format."`
+	TimeUTC         bool      `toml:"timeUTC" comment:"Whether the time prefixed is in UTC instead of local time. False by default." default:"false"`
+	PrefixStream    bool      `toml:"prefixStream" comment:"Whether each line is prefixed with 'stdout' or 'stderr'. False by default." default:"false"`
+	PrefixPid       bool      `toml:"prefixPid" comment:"Whether each line is prefixed with the pid of the process. False by default." default:"false"`
+	StderrTag       string    `toml:"stderrTag" comment:"Prefix of each stderr line written to path, like '[stderr] ', to tell it from stdout. Not tagged by default."`
+	Stdout          LogFile   `toml:"stdout" comment:"Config related with the log of stdout only, written to path along with stderr by default."`
+	Stderr          LogFile   `toml:"stderr" comment:"Config related with the log of stderr only, written to path along with stdout by default."`
+}
+
+// LogFormat is the format of the log of the process output.
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// LogFile is the log of stdout or stderr only.
+type LogFile struct {
+	Path            string `toml:"path" comment:"Path where to save the current un-rotated log of the stream. Written to the log of both streams by default."`
+	MaxSize         int    `toml:"maxSize" comment:"Maximum size in MiB of the log file before it gets rotated, 0 to rotate by rotateSchedule only. 128 MiB by default." default:"128"`
 	MaxDays         int    `toml:"maxDays" comment:"Maximum days to retain old log files based on the UTC time encoded in their filename. unlimited by default." default:"0"`
-	MaxBackups      int    `toml:"maxBackups" comment:"Maximum number of old log files to retain. Retaining all old log files by default. 32 by default." default:"32"`
+	MaxBackups      int    `toml:"maxBackups" comment:"Maximum number of old log files to retain. 32 by default." default:"32"`
 	Compress        bool   `toml:"compress" comment:"Whether the rotated log files should be compressed with gzip, no compression by default." default:"false"`
 	MergeCompressed bool   `toml:"mergeCompressed" comment:"Whether the gzipped backups should be merged, no by default." default:"false"`
+	RotateSchedule  string `toml:"rotateSchedule" comment:"When to rotate the log file in local time besides maxSize, even if the process is idle. Not rotated on schedule by default."`
+}
+
+// HealthCheck probes the supervised process periodically, and restarts it after consecutive failures.
+type HealthCheck struct {
+	Type               HealthCheckType `toml:"type" comment:"Type of the probe. One of 'http', 'tcp', 'exec', or empty to disable health check. Empty by default." default:""`
+	URL                string          `toml:"url" comment:"URL the 'http' probe sends GET request to."`
+	Address            string          `toml:"address" comment:"Address like 'host:port' the 'tcp' probe connects to."`
+	Command            []string        `toml:"command" comment:"Command and its arguments the 'exec' probe runs in the workDir, with the envs, user and group of the supervised process. Healthy if exited with 0."`
+	ExpectStatus       int             `toml:"expectStatus" comment:"Status code the 'http' probe expects. 200 by default." default:"200"`
+	BodyMatch          string          `toml:"bodyMatch" comment:"Regexp the response body of the 'http' probe should match. Matching any by default."`
+	IntervalSeconds    int             `toml:"intervalSeconds" comment:"Seconds between two probes. 10 by default." default:"10"`
+	TimeoutSeconds     int             `toml:"timeoutSeconds" comment:"Maximum seconds of one probe. 3 by default." default:"3"`
+	FailureThreshold   int             `toml:"failureThreshold" comment:"Consecutive failed probes, after which the process is restarted. 3 by default." default:"3"`
+	StartPeriodSeconds int             `toml:"startPeriodSeconds" comment:"Seconds to wait after the process started before the first probe. 0 by default." default:"0"`
+}
+
+// HealthCheckType type of the health check probe.
+type HealthCheckType string
+
+const (
+	HealthCheckNone HealthCheckType = ""
+	HealthCheckHTTP HealthCheckType = "http"
+	HealthCheckTCP  HealthCheckType = "tcp"
+	HealthCheckExec HealthCheckType = "exec"
+)
+
+// Readiness confirms the supervised process started, instead of waiting a fixed startSeconds.
+type Readiness struct {
+	Type     ReadinessType `toml:"type" comment:"How to confirm the process is ready. One of 'probe' passing the health check probe once, 'log' a line of output matching 'logMatch', 'file' the 'file' created or modified, 'notify' READY=1 sent by sd_notify, or empty to wait 'startSeconds' for the process to keep running. Empty by default." default:""`
+	LogMatch string        `toml:"logMatch" comment:"Regexp a line of the process output should match for 'log' readiness."`
+	File     string        `toml:"file" comment:"Path of the file the process creates or modifies once ready for 'file' readiness. Relative path would based on process.workDir."`
+}
+
+// ReadinessType how to confirm the supervised process is ready.
+type ReadinessType string
+
+const (
+	ReadinessNone   ReadinessType = ""
+	ReadinessProbe  ReadinessType = "probe"
+	ReadinessLog    ReadinessType = "log"
+	ReadinessFile   ReadinessType = "file"
+	ReadinessNotify ReadinessType = "notify"
+)
+
+// Notify receives the messages the supervised process sends by sd_notify protocol.
+type Notify struct {
+	Enabled         bool   `toml:"enabled" comment:"Pass NOTIFY_SOCKET to the process, through which it sends messages like READY=1, STATUS=... Implied by 'notify' readiness or a positive 'watchdogSeconds'. False by default." default:"false"`
+	Socket          string `toml:"socket" comment:"Path of the notify socket. Relative path would based on the directory of sup.socket. '<sup.socket>.<program name>.notify' by default."`
+	WatchdogSeconds int    `toml:"watchdogSeconds" comment:"Seconds within which the ready process has to send WATCHDOG=1 each time, or it is restarted. Passed to the process as WATCHDOG_USEC. 0 to disable watchdog. 0 by default." default:"0"`
+}
+
+// Cgroup places the supervised process in a dedicated cgroup v2, to track all its descendant processes.
+type Cgroup struct {
+	Enabled bool   `toml:"enabled" comment:"Place the process in cgroup '<parent>/<program name>' created on each start and removed after exited, through which all its descendant processes are stopped and killed. Process group of the process is used otherwise. False by default." default:"false"`
+	Parent  string `toml:"parent" comment:"Parent of the cgroup, relative to where cgroup v2 mounted like /sys/fs/cgroup. 'sup' by default." default:"sup"`
+}
+
+// Resources limits the resources used by the supervised process and all its descendants through its cgroup.
+type Resources struct {
+	MemoryMax  string `toml:"memoryMax" comment:"Hard limit of memory usage like '512M' or '2G', beyond which the processes are OOM killed. Written to memory.max. Unlimited by default."`
+	MemoryHigh string `toml:"memoryHigh" comment:"Memory usage like '384M', beyond which the processes are throttled and reclaimed heavily. Written to memory.high. Unlimited by default."`
+	CPUMax     string `toml:"cpuMax" comment:"CPU bandwidth as '<quota> <period>' in microseconds like '50000 100000' for half a CPU. Written to cpu.max. Unlimited by default."`
+	PidsMax    int    `toml:"pidsMax" comment:"Maximum number of processes and threads. Written to pids.max. 0 for unlimited. 0 by default." default:"0"`
+	IOWeight   int    `toml:"ioWeight" comment:"Proportional IO weight in [1, 10000] relative to other cgroups. Written to io.weight. 0 to leave the default 100. 0 by default." default:"0"`
+
+	// MemoryMaxBytes and MemoryHighBytes are parsed from MemoryMax and MemoryHigh, 0 if unlimited.
+	MemoryMaxBytes  int64 `toml:"-"`
+	MemoryHighBytes int64 `toml:"-"`
+}
+
+// Controllers returns the cgroup controllers needed by the limits.
+func (r *Resources) Controllers() []string {
+	var controllers []string
+	if r.MemoryMaxBytes > 0 || r.MemoryHighBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if len(r.CPUMax) > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if r.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+	if r.IOWeight > 0 {
+		controllers = append(controllers, "io")
+	}
+	return controllers
 }
@@ -0,0 +1,56 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// ExecProber probes by running a command, healthy if it exits with 0.
+type ExecProber struct {
+	path string
+	args []string
+	dir  string
+	env  []string
+	cred *syscall.Credential
+}
+
+// NewExecProber returns an ExecProber running command in dir with env as the user given by cred, cred could be nil.
+func NewExecProber(command []string, dir string, env []string, cred *syscall.Credential) (*ExecProber, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("expected non-empty command")
+	}
+	return &ExecProber{
+		path: command[0],
+		args: command[1:],
+		dir:  dir,
+		env:  env,
+		cred: cred,
+	}, nil
+}
+
+func (p *ExecProber) Probe(ctx context.Context) (string, error) {
+	var output bytes.Buffer
+	cmd := exec.Command(p.path, p.args...)
+	cmd.Dir = p.dir
+	cmd.Env = p.env
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// in its own process group, so that it could be killed along with its children on timeout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: p.cred}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return output.String(), err
+	case <-ctx.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return output.String(), ctx.Err()
+	}
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecProber(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		command    []string
+		wantOutput string
+		wantErr    bool
+	}{
+		{"exit 0", []string{"/bin/sh", "-c", `echo "$PWD $PROBE"`}, dir + " env\n", false},
+		{"exit 1", []string{"/bin/sh", "-c", "echo down >&2; exit 1"}, "down\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewExecProber(tt.command, dir, []string{"PROBE=env"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			output, err := p.Probe(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, want err %t", err, tt.wantErr)
+			}
+			if output != tt.wantOutput {
+				t.Errorf("got output %q, want %q", output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestExecProberNotFound(t *testing.T) {
+	p, err := NewExecProber([]string{"/nonexistent/probe"}, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Probe(context.Background()); err == nil {
+		t.Error("got nil err for a command not found")
+	}
+}
+
+func TestNewExecProberEmptyCommand(t *testing.T) {
+	if _, err := NewExecProber(nil, "", nil, nil); err == nil {
+		t.Error("got nil err for an empty command")
+	}
+}
+
+// TestExecProberTimeout checks the command and its children are killed on timeout, including those holding the output.
+func TestExecProberTimeout(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	p, err := NewExecProber([]string{"/bin/sh", "-c", `sleep 30 & echo $! > "$PID_FILE"; wait`}, "",
+		[]string{"PID_FILE=" + pidFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Probe(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("probe returned %s after the timeout", elapsed)
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for isAlive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d of the probe still running after timeout", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// isAlive reports whether the process exists and is not a zombie.
+func isAlive(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// the state follows the command enclosed by parentheses.
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,186 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prober checks the health of a program once.
+type Prober interface {
+	// Probe returns a short output describing the result, and an error if unhealthy.
+	Probe(ctx context.Context) (output string, err error)
+}
+
+// States of the health of a program.
+const (
+	StateStarting  = "starting"
+	StateHealthy   = "healthy"
+	StateUnhealthy = "unhealthy"
+)
+
+// Status of the health of a program.
+type Status struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastProbeTime       *time.Time `json:"lastProbeTime,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastOutput          string     `json:"lastOutput,omitempty"`
+}
+
+// Checker probes a program periodically, and calls onUnhealthy once after consecutive failures reaching the threshold.
+type Checker struct {
+	prober Prober
+
+	// interval is the duration between two probes.
+	interval time.Duration
+
+	// timeout is the maximum duration of one probe.
+	timeout time.Duration
+
+	// startPeriod is the duration to wait before the first probe, giving the program time to start.
+	startPeriod time.Duration
+
+	// failureThreshold is the number of consecutive failures, after which the program is unhealthy.
+	failureThreshold int
+
+	// onUnhealthy is called once the program became unhealthy, and the checker stops probing.
+	onUnhealthy func()
+
+	mu     sync.Mutex
+	status Status
+}
+
+type Option func(*Checker)
+
+func WithInterval(interval time.Duration) Option {
+	return func(c *Checker) {
+		c.interval = interval
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = timeout
+	}
+}
+
+func WithStartPeriod(startPeriod time.Duration) Option {
+	return func(c *Checker) {
+		c.startPeriod = startPeriod
+	}
+}
+
+func WithFailureThreshold(failureThreshold int) Option {
+	return func(c *Checker) {
+		c.failureThreshold = failureThreshold
+	}
+}
+
+func WithOnUnhealthy(onUnhealthy func()) Option {
+	return func(c *Checker) {
+		c.onUnhealthy = onUnhealthy
+	}
+}
+
+func NewChecker(prober Prober, opts ...Option) (*Checker, error) {
+	c := &Checker{
+		prober:           prober,
+		interval:         10 * time.Second,
+		timeout:          3 * time.Second,
+		failureThreshold: 3,
+		onUnhealthy:      func() {},
+		status:           Status{State: StateStarting},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("expected interval > 0, got %s", c.interval)
+	}
+	if c.timeout <= 0 {
+		return nil, fmt.Errorf("expected timeout > 0, got %s", c.timeout)
+	}
+	if c.failureThreshold <= 0 {
+		return nil, fmt.Errorf("expected failureThreshold > 0, got %d", c.failureThreshold)
+	}
+	return c, nil
+}
+
+// Run probes until stop closed or the program became unhealthy.
+func (c *Checker) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	timer := time.NewTimer(c.startPeriod)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if !c.probe(ctx) {
+			c.onUnhealthy()
+			return
+		}
+		timer.Reset(c.interval)
+	}
+}
+
+// probe probes once and returns false if the program became unhealthy.
+func (c *Checker) probe(ctx context.Context) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	output, err := c.prober.Probe(probeCtx)
+	cancel()
+	if ctx.Err() != nil {
+		// stopped while probing
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.status.LastProbeTime = &now
+	c.status.LastOutput = truncate(output, maxOutputBytes)
+	if err == nil {
+		c.status.State = StateHealthy
+		c.status.ConsecutiveFailures = 0
+		c.status.LastError = ""
+		return true
+	}
+	c.status.ConsecutiveFailures++
+	c.status.LastError = err.Error()
+	if c.status.ConsecutiveFailures < c.failureThreshold {
+		return true
+	}
+	c.status.State = StateUnhealthy
+	return false
+}
+
+// Status returns a copy of the current health status.
+func (c *Checker) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// maxOutputBytes is the maximum bytes of the probe output kept in status.
+const maxOutputBytes = 1024
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
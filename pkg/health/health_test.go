@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProber returns the results in order, and the last one repeatedly.
+type fakeProber struct {
+	mu      sync.Mutex
+	results []error
+	probes  int
+}
+
+func (p *fakeProber) Probe(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.results[len(p.results)-1]
+	if p.probes < len(p.results) {
+		err = p.results[p.probes]
+	}
+	p.probes++
+	return "probed", err
+}
+
+// blockingProber blocks until the probe timed out.
+type blockingProber struct{}
+
+func (blockingProber) Probe(ctx context.Context) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestCheckerFailureThreshold(t *testing.T) {
+	errDown := errors.New("down")
+	tests := []struct {
+		name      string
+		results   []error
+		threshold int
+		// wantProbes is the number of probes until unhealthy.
+		wantProbes int
+	}{
+		{"unhealthy at the first failure", []error{errDown}, 1, 1},
+		{"unhealthy after consecutive failures", []error{nil, errDown}, 3, 4},
+		{"success resets failures", []error{errDown, errDown, nil, errDown}, 3, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := &fakeProber{results: tt.results}
+			unhealthy := make(chan struct{})
+			c, err := NewChecker(prober,
+				WithInterval(time.Millisecond),
+				WithFailureThreshold(tt.threshold),
+				WithOnUnhealthy(func() { close(unhealthy) }),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stop := make(chan struct{})
+			defer close(stop)
+			go c.Run(stop)
+			select {
+			case <-unhealthy:
+			case <-time.After(5 * time.Second):
+				t.Fatal("not unhealthy in 5s")
+			}
+			st := c.Status()
+			if st.State != StateUnhealthy || st.ConsecutiveFailures != tt.threshold || st.LastError != errDown.Error() ||
+				st.LastOutput != "probed" {
+				t.Errorf("got status %+v", st)
+			}
+			prober.mu.Lock()
+			defer prober.mu.Unlock()
+			if prober.probes != tt.wantProbes {
+				t.Errorf("got %d probes, want %d", prober.probes, tt.wantProbes)
+			}
+		})
+	}
+}
+
+func TestCheckerHealthy(t *testing.T) {
+	c, err := NewChecker(&fakeProber{results: []error{nil}}, WithInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Status(); st.State != StateStarting {
+		t.Errorf("got state %q before probed, want %q", st.State, StateStarting)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Status().State != StateHealthy {
+		if time.Now().After(deadline) {
+			t.Fatal("not healthy in 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	unhealthy := make(chan struct{})
+	c, err := NewChecker(blockingProber{},
+		WithInterval(time.Millisecond),
+		WithTimeout(50*time.Millisecond),
+		WithFailureThreshold(2),
+		WithOnUnhealthy(func() { close(unhealthy) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+	select {
+	case <-unhealthy:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not unhealthy in 5s")
+	}
+	if st := c.Status(); st.LastError != context.DeadlineExceeded.Error() {
+		t.Errorf("got last error %q, want %q", st.LastError, context.DeadlineExceeded)
+	}
+}
+
+func TestNewCheckerInvalid(t *testing.T) {
+	for _, opt := range []Option{WithInterval(0), WithTimeout(0), WithFailureThreshold(0)} {
+		if _, err := NewChecker(blockingProber{}, opt); err == nil {
+			t.Error("got nil err for an invalid option")
+		}
+	}
+}
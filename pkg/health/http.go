@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+// maxBodyBytes is the maximum bytes of the response body read to match.
+const maxBodyBytes = 64 * 1024
+
+// HTTPProber probes by HTTP GET, healthy if responded with the expected status code and body.
+type HTTPProber struct {
+	url          string
+	expectStatus int
+	bodyMatch    *regexp.Regexp
+	client       *http.Client
+}
+
+// NewHTTPProber returns a HTTPProber, bodyMatch is a regexp the response body should match, or empty to match any.
+func NewHTTPProber(url string, expectStatus int, bodyMatch string) (*HTTPProber, error) {
+	p := &HTTPProber{
+		url:          url,
+		expectStatus: expectStatus,
+		client: &http.Client{
+			// a redirection is treated as a response
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+	if len(bodyMatch) > 0 {
+		re, err := regexp.Compile(bodyMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bodyMatch %q: %s", bodyMatch, err)
+		}
+		p.bodyMatch = re
+	}
+	return p, nil
+}
+
+func (p *HTTPProber) Probe(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return "", err
+	}
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, maxBodyBytes))
+	if err != nil {
+		return rsp.Status, fmt.Errorf("read body: %s", err)
+	}
+	output := rsp.Status + " " + string(body)
+	if rsp.StatusCode != p.expectStatus {
+		return output, fmt.Errorf("got status code %d, want %d", rsp.StatusCode, p.expectStatus)
+	}
+	if p.bodyMatch != nil && !p.bodyMatch.Match(body) {
+		return output, fmt.Errorf("body not matching %q", p.bodyMatch)
+	}
+	return output, nil
+}
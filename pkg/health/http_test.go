@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPProber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			_, _ = w.Write([]byte(`{"status":"up"}`))
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"status":"down"}`))
+		case "/redirect":
+			w.Header().Set("Location", "/ok")
+			w.WriteHeader(http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		expectStatus int
+		bodyMatch    string
+		wantOutput   string
+		wantErr      bool
+	}{
+		{"ok", "/ok", 200, "", `200 OK {"status":"up"}`, false},
+		{"body matched", "/ok", 200, `"status":\s*"up"`, `200 OK {"status":"up"}`, false},
+		{"body not matched", "/down", 503, `"status":\s*"up"`, `503 Service Unavailable {"status":"down"}`, true},
+		{"unexpected status", "/down", 200, "", `503 Service Unavailable {"status":"down"}`, true},
+		{"redirect not followed", "/redirect", 302, "", "302 Found ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewHTTPProber(srv.URL+tt.path, tt.expectStatus, tt.bodyMatch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			output, err := p.Probe(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, want err %t", err, tt.wantErr)
+			}
+			if output != tt.wantOutput {
+				t.Errorf("got output %q, want %q", output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestHTTPProberTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	p, err := NewHTTPProber(srv.URL, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Probe(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("probe returned %s after the timeout", elapsed)
+	}
+}
+
+func TestNewHTTPProberInvalidBodyMatch(t *testing.T) {
+	if _, err := NewHTTPProber("http://127.0.0.1", 200, "("); err == nil {
+		t.Error("got nil err for invalid bodyMatch")
+	}
+}
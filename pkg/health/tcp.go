@@ -0,0 +1,25 @@
+package health
+
+import (
+	"context"
+	"net"
+)
+
+// TCPProber probes by connecting to a TCP address, healthy if the connection is accepted.
+type TCPProber struct {
+	address string
+	dialer  net.Dialer
+}
+
+func NewTCPProber(address string) *TCPProber {
+	return &TCPProber{address: address}
+}
+
+func (p *TCPProber) Probe(ctx context.Context) (string, error) {
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return "", err
+	}
+	output := "connected to " + conn.RemoteAddr().String()
+	return output, conn.Close()
+}
@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTCPProber(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	output, err := NewTCPProber(ln.Addr().String()).Probe(ctx)
+	if err != nil {
+		t.Fatalf("probe listening address: %s", err)
+	}
+	if want := "connected to " + ln.Addr().String(); output != want {
+		t.Errorf("got output %q, want %q", output, want)
+	}
+}
+
+func TestTCPProberRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens on the address once closed.
+	address := ln.Addr().String()
+	_ = ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := NewTCPProber(address).Probe(ctx); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("got err %v, want connection refused", err)
+	}
+}
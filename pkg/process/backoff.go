@@ -0,0 +1,42 @@
+package process
+
+import (
+	"math"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+)
+
+// backoff computes the delays between automatic restarts, and gives up after too many restarts within a window.
+type backoff struct {
+	config *config.Backoff
+	// attempts is the number of restarts since the last reset.
+	attempts int
+	// history holds the instants of restarts within the window.
+	history []time.Time
+}
+
+// next returns the delay before the next restart, or false if there were already maxRetries restarts in the window.
+func (b *backoff) next(now time.Time) (time.Duration, bool) {
+	window := time.Duration(b.config.WindowSeconds) * time.Second
+	i := 0
+	for ; i < len(b.history) && now.Sub(b.history[i]) > window; i++ {
+	}
+	b.history = b.history[i:]
+	if b.config.MaxRetries > 0 && len(b.history) >= b.config.MaxRetries {
+		return 0, false
+	}
+
+	initial := float64(b.config.InitialSeconds) * float64(time.Second)
+	max := float64(b.config.MaxSeconds) * float64(time.Second)
+	delay := math.Min(initial*math.Pow(float64(b.config.Multiplier), float64(b.attempts)), max)
+
+	b.attempts++
+	b.history = append(b.history, now)
+	return time.Duration(delay), true
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+	b.history = nil
+}
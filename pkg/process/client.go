@@ -1,10 +1,14 @@
 package process
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/rpc"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/sequix/sup/pkg/config"
@@ -32,36 +36,150 @@ func ClientClose() {
 	}
 }
 
-func Start() error {
-	return client.Call("Controller.Start", &Request{}, &Response{})
+func Start(program string) error {
+	return client.Call("Controller.Start", &Request{Program: program}, &Response{})
 }
 
-func Stop() error {
-	return client.Call("Controller.Stop", &Request{}, &Response{})
+func Stop(program string) error {
+	rsp := &Response{}
+	if err := client.Call("Controller.Stop", &Request{Program: program}, rsp); err != nil {
+		return err
+	}
+	printForced(rsp)
+	return nil
 }
 
-func Restart() error {
-	return client.Call("Controller.Restart", &Request{}, &Response{})
+func Restart(program string) error {
+	rsp := &Response{}
+	if err := client.Call("Controller.Restart", &Request{Program: program}, rsp); err != nil {
+		return err
+	}
+	printForced(rsp)
+	return nil
 }
 
-func Reload() error {
-	return client.Call("Controller.Reload", &Request{}, &Response{})
+func Reload(program string) error {
+	return client.Call("Controller.Reload", &Request{Program: program}, &Response{})
 }
 
-func Kill() error {
-	return client.Call("Controller.Kill", &Request{}, &Response{})
+// ReloadConfig reloads the config file of the daemon, and prints the changes.
+func ReloadConfig() error {
+	rsp := &Response{}
+	if err := client.Call("Controller.ReloadConfig", &Request{}, rsp); err != nil {
+		return err
+	}
+	fmt.Print(rsp.Message)
+	return nil
 }
 
-func Status() error {
+func Kill(program string) error {
+	return client.Call("Controller.Kill", &Request{Program: program}, &Response{})
+}
+
+// Status prints the status of programs in the given output format, one of OutputTable and OutputJSON.
+func Status(program, output string) error {
 	rsp := &Response{}
-	if err := client.Call("Controller.Status", &Request{}, &rsp); err != nil {
+	if err := client.Call("Controller.Status", &Request{Program: program}, &rsp); err != nil {
 		return err
 	}
-	fmt.Print(rsp.Message)
+	switch output {
+	case OutputJSON:
+		return printStatusJSON(rsp.Statuses)
+	case OutputTable:
+		return printStatusTable(rsp.Statuses)
+	default:
+		return fmt.Errorf("unknown output format %q, want one of [%s, %s]", output, OutputTable, OutputJSON)
+	}
+}
+
+func printStatusJSON(statuses []ProgramStatus) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(statuses)
+}
+
+func printStatusTable(statuses []ProgramStatus) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tHEALTH\tPID\tUPTIME\tRESTARTS\tLAST-EXIT\tRSS\tCPU\tFDS\tTHREADS\tCHILDREN\tCOMMAND")
+	for _, st := range statuses {
+		state := string(st.State)
+		if st.RetryTime != nil {
+			state += fmt.Sprintf("(%s)", time.Until(*st.RetryTime).Round(time.Second))
+		}
+		if st.NotifyState == notifyReloading || st.NotifyState == notifyStopping {
+			state += fmt.Sprintf("(%s)", st.NotifyState)
+		}
+		lastExit := "-"
+		if len(st.LastSignal) > 0 {
+			lastExit = st.LastSignal
+		} else if st.Exited {
+			lastExit = strconv.Itoa(st.LastExitCode)
+		}
+		healthState := "-"
+		if st.Health != nil {
+			healthState = st.Health.State
+		}
+		if st.Pid == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t%d\t%s\t-\t-\t-\t-\t-\t-\n", st.Name, state, st.Restarts, lastExit)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			st.Name, state, healthState, st.Pid, time.Duration(st.UptimeSeconds)*time.Second, st.Restarts, lastExit,
+			formatBytes(st.RSSBytes), time.Duration(st.CPUSeconds*float64(time.Second)).Round(time.Millisecond),
+			st.FDs, st.Threads, len(st.Children), st.Command)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, st := range statuses {
+		if len(st.Error) > 0 {
+			fmt.Printf("%s: %s\n", st.Name, st.Error)
+		}
+		if len(st.NotifyStatus) > 0 {
+			fmt.Printf("%s: notified status: %s\n", st.Name, st.NotifyStatus)
+		}
+		if st.Pid != 0 && st.AttrsConfigured {
+			limits := make([]string, 0, len(rlimitNames))
+			for _, name := range rlimitNames {
+				limits = append(limits, name+" "+st.Rlimits[name])
+			}
+			fmt.Printf("%s: nice %d, ionice %s, umask %s, oom_score_adj %d, %s\n", st.Name, st.Nice, st.IOPriority,
+				st.Umask, st.OOMScoreAdj, strings.Join(limits, ", "))
+		}
+		if st.Cgroup != nil {
+			fmt.Printf("%s: cgroup memory %s, cpu %s, pids %d, oom kills %d\n", st.Name, formatBytes(st.Cgroup.MemoryBytes),
+				time.Duration(st.Cgroup.CPUSeconds*float64(time.Second)).Round(time.Millisecond), st.Cgroup.Pids, st.OOMKills)
+		} else if st.OOMKills > 0 {
+			fmt.Printf("%s: oom kills %d\n", st.Name, st.OOMKills)
+		}
+		if st.Health != nil && len(st.Health.LastOutput) > 0 {
+			fmt.Printf("%s: last probe output: %s\n", st.Name, st.Health.LastOutput)
+		}
+		if st.Health != nil && len(st.Health.LastError) > 0 {
+			fmt.Printf("%s: last probe failed: %s\n", st.Name, st.Health.LastError)
+		}
+	}
 	return nil
 }
 
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func Exit() error {
+	// Stop all programs before signaling sup, so that forced stops could be reported.
+	if err := Stop(""); err != nil {
+		log.Error("stop programs before exit: %s", err)
+	}
 	rsp := &Response{}
 	if err := client.Call("Controller.SupPid", &Request{}, &rsp); err != nil {
 		return err
@@ -79,8 +197,14 @@ func Exit() error {
 	return nil
 }
 
+func printForced(rsp *Response) {
+	for _, program := range rsp.Forced {
+		fmt.Printf("program %s was killed since it did not exit in stopTimeout\n", program)
+	}
+}
+
+// isPidRunning reports whether the process is alive, zombies exited but not reaped yet are not.
 func isPidRunning(pid int) bool {
-	statPath := fmt.Sprintf("/proc/%d/stat", pid)
-	_, err := os.Stat(statPath)
-	return err == nil
+	stat, err := readProcStat(pid)
+	return err == nil && stat.state != "Z"
 }
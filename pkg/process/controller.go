@@ -1,14 +1,13 @@
 package process
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,58 +15,422 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/sequix/sup/pkg/cgroup"
 	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/health"
 	"github.com/sequix/sup/pkg/log"
-	"github.com/sequix/sup/pkg/rotate"
+	"github.com/sequix/sup/pkg/run"
+	"github.com/sequix/sup/pkg/spawn"
 )
 
 type Controller struct {
-	mu           sync.Mutex
-	cmd          *exec.Cmd
-	logWritePipe *io.PipeWriter
-	logReadPipe  *io.PipeReader
-	logger       *rotate.FileWriter
-	startedCh    chan struct{}
-	exitedCh     chan struct{}
-	wantStop     int32
-	wantExit     int32
+	name   string
+	config *config.Program
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	// template is what the cmd of each start is created from, except the env.
+	template *exec.Cmd
+	// baseEnv is the env inherited from sup and of the user, under those given by config.
+	baseEnv     map[string]string
+	loggers     *loggers
+	notifier    *notifier
+	startedCh   chan *exec.Cmd
+	exitedCh    chan *exec.Cmd
+	unhealthyCh chan *exec.Cmd
+	wantStop    int32
+	wantExit    int32
+	// descendants has its own lock, since it is updated by the reaper too.
+	descendants descendants
+
+	// guarded by mu
+	state     State
+	startedAt time.Time
+	retryAt   time.Time
+	backoff   backoff
+	restarts  int
+	lastExit  *os.ProcessState
+	checker   *health.Checker
+	checkerRw *run.Runner
+	// cgroup is nil if disabled or the program not started.
+	cgroup *cgroup.Cgroup
+	// oomKills in the cgroups removed.
+	oomKills int
+	// env of the last start.
+	env []string
+	// pending is the config reloaded but not applied yet, nil if none.
+	pending *pendingConfig
+	// mainPid is the MAINPID notified by the program, 0 if not notified.
+	mainPid int
+	// mainStartTime is the start time of the main process notified, against pid reuse.
+	mainStartTime int64
+	// orphaned is whether the process started by sup exited successfully, leaving the other processes supervised.
+	orphaned bool
+	// readyCh is closed once READY=1 notified while starting.
+	readyCh         chan struct{}
+	notifyState     string
+	notifyStatus    string
+	watchdog        *time.Timer
+	watchdogTimeout time.Duration
 }
 
 func (c *Controller) run(stop <-chan struct{}) {
+	// retry fires when the program waiting in Backoff state should be restarted.
+	var retry <-chan time.Time
+	if c.config.Process.AutoStart {
+		retry = c.autoStart()
+	}
 	for {
 		select {
 		case <-stop:
 			c.setWantExit()
 			_ = c.Stop(nil, nil)
 			return
-		case <-c.startedCh:
-			go c.wait()
-		case <-c.exitedCh:
+		case cmd := <-c.startedCh:
+			go c.wait(cmd)
+		case cmd := <-c.exitedCh:
 			if c.getWantExit() {
 				return
 			}
-			if c.getWantStop() {
-				continue
+			if ch := c.exited(cmd); ch != nil {
+				retry = ch
 			}
-			switch processConfig.RestartStrategy {
-			case config.RestartStrategyNone:
-			case config.RestartStrategyAlways:
-				c.mustStart()
-			case config.RestartStrategyOnFailure:
-				if !c.cmd.ProcessState.Success() {
-					c.mustStart()
-				}
+		case cmd := <-c.unhealthyCh:
+			if ch := c.restartUnhealthy(cmd); ch != nil {
+				retry = ch
 			}
+		case <-retry:
+			retry = c.retryStart()
+		}
+	}
+}
+
+// exited handles the exit of cmd, and returns a channel fires when the program should be restarted, if it should be.
+func (c *Controller) exited(cmd *exec.Cmd) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastExit = cmd.ProcessState
+	if cmd != c.cmd {
+		// the program has been started again, e.g. by restart.
+		closeLogPipes(cmd)
+		return nil
+	}
+	stat := cmd.ProcessState
+	if c.mainPid > 0 && c.mainPid != cmd.Process.Pid {
+		if c.mainPidRunning() {
+			log.Info("program %s %d exited, keep supervising its main process %d", c.name, cmd.Process.Pid, c.mainPid)
+			go c.waitMainPid(cmd, c.mainPid, c.mainStartTime)
+			return nil
+		}
+		// the exit status of the main process is unknown.
+		stat = nil
+	} else if c.orphaned {
+		// the exit status of the remaining processes is unknown.
+		stat = nil
+	} else if !c.failed(stat) && atomic.LoadInt32(&c.wantStop) == 0 && c.running() {
+		// e.g. a daemon forking itself to the background.
+		pids, _ := c.processes()
+		log.Info("program %s %d exited, keep supervising its remaining processes %v", c.name, cmd.Process.Pid, pids)
+		c.orphaned = true
+		go c.waitRemaining(cmd)
+		return nil
+	}
+	closeLogPipes(cmd)
+	c.stopHealthCheck()
+	c.stopWatchdog()
+	if c.running() {
+		log.Warn("killing remaining processes of program %s %d exited", c.name, cmd.Process.Pid)
+		if err := c.killAction(); err != nil {
+			log.Error("kill remaining processes of program %s: %s", c.name, err)
+		}
+	}
+	c.removeCgroup()
+	if c.getWantStop() {
+		c.state = StateStopped
+		return nil
+	}
+	if stat != nil {
+		if code := stat.ExitCode(); containsInt(c.config.Process.NoRestartExitCodes, code) {
+			log.Info("program %s exited with code %d in noRestartExitCodes, not restarting", c.name, code)
+			c.state = StateExited
+			return nil
+		}
+	}
+	switch c.config.Process.RestartStrategy {
+	case config.RestartStrategyAlways:
+		return c.scheduleRestart()
+	case config.RestartStrategyOnFailure:
+		if c.failed(stat) {
+			return c.scheduleRestart()
 		}
 	}
+	c.state = StateExited
+	return nil
+}
+
+// failed reports whether the program exited with failure, according to successExitCodes and signalIsFailure.
+func (c *Controller) failed(stat *os.ProcessState) bool {
+	if stat == nil {
+		return true
+	}
+	if ws, ok := stat.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return c.config.Process.SignalIsFailure
+	}
+	return !containsInt(c.config.Process.SuccessExitCodes, stat.ExitCode())
+}
+
+// scheduleRestart puts the program into Backoff state, and returns a channel fires when it should be restarted.
+// If the program has been restarted too many times, it enters Fatal state and nil is returned.
+// The caller must hold c.mu.
+func (c *Controller) scheduleRestart() <-chan time.Time {
+	now := time.Now()
+	if !c.startedAt.IsZero() && now.Sub(c.startedAt) >= time.Duration(c.config.Process.Backoff.ResetSeconds)*time.Second {
+		c.backoff.reset()
+	}
+	delay, ok := c.backoff.next(now)
+	if !ok {
+		c.state = StateFatal
+		log.Error("program %s restarted %d times within %d seconds, giving up", c.name,
+			c.config.Process.Backoff.MaxRetries, c.config.Process.Backoff.WindowSeconds)
+		return nil
+	}
+	c.state = StateBackoff
+	c.retryAt = now.Add(delay)
+	log.Info("restarting program %s in %s", c.name, delay)
+	return time.After(delay)
+}
+
+// autoStart starts the program as sup goes up, and returns a channel fires when it should be retried if failed,
+// as if it failed to restart automatically.
+func (c *Controller) autoStart() <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backoff.reset()
+	if err := c.start(); err != nil && c.state == StateExited {
+		return c.scheduleRestart()
+	}
+	return nil
+}
+
+// retryStart starts the program if it is still waiting in Backoff state,
+// and returns a channel fires when it should be retried again if failed.
+func (c *Controller) retryStart() <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != StateBackoff {
+		return nil
+	}
+	if err := c.start(); err != nil && c.state == StateExited {
+		return c.scheduleRestart()
+	}
+	return nil
+}
+
+// restartUnhealthy restarts the program if cmd is still running, and returns a channel fires
+// when it should be retried if failed to start.
+func (c *Controller) restartUnhealthy(cmd *exec.Cmd) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cmd != c.cmd || c.state != StateRunning {
+		return nil
+	}
+	log.Warn("restarting unhealthy program %s %d", c.name, c.pid())
+	if err := c.stopAction(nil); err != nil {
+		log.Error("stop unhealthy program %s %d: %s", c.name, cmd.Process.Pid, err)
+		return nil
+	}
+	if err := c.start(); err != nil && c.state == StateExited {
+		return c.scheduleRestart()
+	}
+	return nil
+}
+
+// startHealthCheck starts probing the program just started. The caller must hold c.mu.
+func (c *Controller) startHealthCheck() {
+	c.stopHealthCheck()
+	c.checker = nil
+	hc := &c.config.HealthCheck
+	if hc.Type == config.HealthCheckNone {
+		return
+	}
+	prober, err := c.newProber(hc)
+	if err != nil {
+		log.Error("create health check prober of program %s: %s", c.name, err)
+		return
+	}
+	cmd := c.cmd
+	checker, err := health.NewChecker(prober,
+		health.WithInterval(time.Duration(hc.IntervalSeconds)*time.Second),
+		health.WithTimeout(time.Duration(hc.TimeoutSeconds)*time.Second),
+		health.WithStartPeriod(time.Duration(hc.StartPeriodSeconds)*time.Second),
+		health.WithFailureThreshold(hc.FailureThreshold),
+		health.WithOnUnhealthy(func() {
+			log.Warn("program %s %d unhealthy after %d consecutive failed probes", c.name, cmd.Process.Pid, hc.FailureThreshold)
+			go func() { c.unhealthyCh <- cmd }()
+		}),
+	)
+	if err != nil {
+		log.Error("create health checker of program %s: %s", c.name, err)
+		return
+	}
+	c.checker = checker
+	c.checkerRw = run.Run(checker.Run)
 }
 
-func (c *Controller) mustStart() {
+// stopHealthCheck stops probing the program. The caller must hold c.mu.
+func (c *Controller) stopHealthCheck() {
+	if c.checkerRw != nil {
+		c.checkerRw.StopAndWait()
+		c.checkerRw = nil
+	}
+}
+
+// startWatchdog restarts the program if it does not notify WATCHDOG=1 within the watchdog timeout.
+// The caller must hold c.mu.
+func (c *Controller) startWatchdog() {
+	c.stopWatchdog()
+	if c.watchdogTimeout <= 0 {
+		return
+	}
+	cmd, timeout := c.cmd, c.watchdogTimeout
+	c.watchdog = time.AfterFunc(timeout, func() {
+		log.Warn("program %s %d notified no WATCHDOG=1 in %s", c.name, cmd.Process.Pid, timeout)
+		go func() { c.unhealthyCh <- cmd }()
+	})
+}
+
+// stopWatchdog the caller must hold c.mu.
+func (c *Controller) stopWatchdog() {
+	if c.watchdog != nil {
+		c.watchdog.Stop()
+		c.watchdog = nil
+	}
+}
+
+// notified handles the sd_notify message sent by the program.
+func (c *Controller) notified(msg map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != StateStarting && c.state != StateRunning {
+		log.Warn("ignored notify message of program %s in %s state: %v", c.name, c.state, msg)
+		return
+	}
+	if v, ok := msg["MAINPID"]; ok {
+		pid, err := strconv.Atoi(v)
+		if err == nil && pid > 0 && c.config.Process.Cloneflags&syscall.CLONE_NEWPID != 0 {
+			// the pid is of the namespace of the program.
+			pid, err = c.hostPid(pid)
+		}
+		if err != nil || pid <= 0 {
+			log.Warn("program %s notified invalid MAINPID %q", c.name, v)
+		} else if pid != c.pid() {
+			c.setMainPid(pid)
+		}
+	}
+	if v, ok := msg["STATUS"]; ok {
+		c.notifyStatus = v
+	}
+	if msg["RELOADING"] == "1" {
+		log.Info("program %s %d notified reloading", c.name, c.pid())
+		c.notifyState = notifyReloading
+	}
+	if msg["STOPPING"] == "1" {
+		log.Info("program %s %d notified stopping", c.name, c.pid())
+		c.notifyState = notifyStopping
+	}
+	if msg["READY"] == "1" {
+		c.notifyState = notifyReady
+		if c.readyCh != nil {
+			close(c.readyCh)
+			c.readyCh = nil
+		}
+	}
+	if v, ok := msg["WATCHDOG_USEC"]; ok {
+		if usec, err := strconv.ParseInt(v, 10, 64); err != nil || usec <= 0 {
+			log.Warn("program %s notified invalid WATCHDOG_USEC %q", c.name, v)
+		} else {
+			c.watchdogTimeout = time.Duration(usec) * time.Microsecond
+			if c.state == StateRunning {
+				c.startWatchdog()
+			}
+		}
+	}
+	switch msg["WATCHDOG"] {
+	case "1":
+		if c.watchdog != nil {
+			c.watchdog.Reset(c.watchdogTimeout)
+		}
+	case "trigger":
+		if c.state == StateRunning {
+			log.Warn("program %s %d triggered watchdog", c.name, c.pid())
+			c.stopWatchdog()
+			cmd := c.cmd
+			go func() { c.unhealthyCh <- cmd }()
+		}
+	}
+}
+
+// setMainPid makes the process notified by MAINPID the main process, if it is one of the processes of the program.
+// Otherwise, e.g. MAINPID=1, it would be signaled as the program. The caller must hold c.mu.
+func (c *Controller) setMainPid(pid int) {
+	pids, err := c.processes()
+	if err != nil {
+		log.Error("list processes of program %s: %s", c.name, err)
+		return
+	}
+	stat, err := readProcStat(pid)
+	if !containsInt(pids, pid) || err != nil {
+		log.Warn("program %s notified MAINPID %d not one of its processes, ignored", c.name, pid)
+		return
+	}
+	log.Info("program %s notified main pid %d", c.name, pid)
+	c.mainPid = pid
+	c.mainStartTime = stat.startTime
+	c.descendants.addRoot(pid, stat.startTime)
+}
+
+// notify states of the program.
+const (
+	notifyReady     = "ready"
+	notifyReloading = "reloading"
+	notifyStopping  = "stopping"
+)
+
+// waitMainPid waits the main process notified by MAINPID to exit, after cmd exited.
+func (c *Controller) waitMainPid(cmd *exec.Cmd, pid int, startTime int64) {
+	for isProcessRunning(pid, startTime) {
+		time.Sleep(time.Second)
+	}
+	log.Info("main process %d of program %s exited", pid, c.name)
+	c.exitedCh <- cmd
+}
+
+// waitRemaining waits the remaining processes of the program to exit, after cmd exited.
+func (c *Controller) waitRemaining(cmd *exec.Cmd) {
 	for {
-		if err := c.startHandler(); err == nil {
-			return
+		time.Sleep(time.Second)
+		c.mu.Lock()
+		done := c.cmd != cmd || !c.running()
+		c.mu.Unlock()
+		if done {
+			break
 		}
 	}
+	log.Info("remaining processes of program %s exited", c.name)
+	c.exitedCh <- cmd
+}
+
+func (c *Controller) newProber(hc *config.HealthCheck) (health.Prober, error) {
+	switch hc.Type {
+	case config.HealthCheckHTTP:
+		return health.NewHTTPProber(hc.URL, hc.ExpectStatus, hc.BodyMatch)
+	case config.HealthCheckTCP:
+		return health.NewTCPProber(hc.Address), nil
+	case config.HealthCheckExec:
+		return health.NewExecProber(hc.Command, filepath.Join(c.config.Process.Chroot, c.template.Dir), c.env,
+			c.template.SysProcAttr.Credential)
+	default:
+		return nil, fmt.Errorf("unknown health check type %q", hc.Type)
+	}
 }
 
 func (c *Controller) Start(_ *Request, _ *Response) (err error) {
@@ -78,122 +441,227 @@ func (c *Controller) Start(_ *Request, _ *Response) (err error) {
 func (c *Controller) startHandler() (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	log.Info("starting program")
+	c.backoff.reset()
+	return c.start()
+}
+
+func (c *Controller) start() (err error) {
+	log.Info("starting program %s", c.name)
 	if err = c.startAction(); err == nil {
-		log.Info("started program %d", c.cmd.Process.Pid)
+		log.Info("started program %s %d", c.name, c.pid())
 	} else {
-		log.Error("start program: %s", err)
+		log.Error("start program %s: %s", c.name, err)
 	}
 	return
 }
 
+// startAction starts the program and waits it to be ready. c.mu is released while waiting,
+// during which the program is in Starting state and could be stopped or killed.
+// If failed to start, the program enters Exited state, unless it was stopped while starting.
 func (c *Controller) startAction() error {
+	if c.state == StateStarting {
+		return errors.New("already starting")
+	}
 	if c.running() {
 		return nil
 	}
-	c.cmd.Process = nil
-	c.logReadPipe, c.logWritePipe = io.Pipe()
-	c.cmd.Stdout = c.logWritePipe
-	c.cmd.Stderr = c.logWritePipe
-	go func() {
-		written, err := io.Copy(c.logger, c.logReadPipe)
-		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
-			log.Error("stopped logger harvest, written %d bytes, err %s", written, err)
+	if err := c.applyPending(); err != nil {
+		c.state = StateExited
+		return err
+	}
+	env, files, err := c.environ()
+	if err != nil {
+		c.state = StateExited
+		return err
+	}
+	defer closeFiles(files)
+	c.env = env
+	// exec.Cmd cannot be reused, so a new one is created from the template for each start.
+	cmd := &exec.Cmd{
+		Path:        c.template.Path,
+		Args:        c.template.Args,
+		Env:         env,
+		Dir:         c.template.Dir,
+		ExtraFiles:  files,
+		SysProcAttr: c.template.SysProcAttr,
+	}
+	if err := spawn.Wrap(cmd, c.spawnAttr()); err != nil {
+		c.state = StateExited
+		return err
+	}
+	c.cmd = cmd
+	c.mainPid = 0
+	c.orphaned = false
+	c.readyCh = nil
+	c.notifyState = ""
+	c.notifyStatus = ""
+	c.watchdogTimeout = time.Duration(c.config.Notify.WatchdogSeconds) * time.Second
+
+	var (
+		rc      = &c.config.Readiness
+		matcher *lineMatcher
+		matched <-chan struct{}
+		prober  health.Prober
+	)
+	switch rc.Type {
+	case config.ReadinessLog:
+		matcher = newLineMatcher(regexp.MustCompile(rc.LogMatch))
+		matched = matcher.matched
+	case config.ReadinessProbe:
+		var err error
+		if prober, err = c.newProber(&c.config.HealthCheck); err != nil {
+			c.state = StateExited
+			return fmt.Errorf("create readiness prober: %s", err)
 		}
-	}()
-	if err := c.cmd.Start(); err != nil {
+	case config.ReadinessNotify:
+		c.readyCh = make(chan struct{})
+		matched = c.readyCh
+	}
+	c.loggers.pipe(c.name, cmd, matcher)
+
+	if c.config.Cgroup.Enabled {
+		dir, err := c.intoCgroup(cmd)
+		if err != nil {
+			closeLogPipes(cmd)
+			c.state = StateExited
+			return fmt.Errorf("prepare cgroup: %s", err)
+		}
+		defer dir.Close()
+	}
+	startedAt := time.Now()
+	if err := cmd.Start(); err != nil {
+		closeLogPipes(cmd)
+		c.removeCgroup()
+		c.state = StateExited
 		return fmt.Errorf("start program: %s", err)
 	}
-	time.Sleep(time.Duration(config.G.ProgramConfig.Process.StartSeconds) * time.Second)
-	if !c.running() {
-		_ = c.logReadPipe.Close()
-		_ = c.logWritePipe.Close()
-		return fmt.Errorf("program not running after %d seconds", config.G.ProgramConfig.Process.StartSeconds)
+	c.descendants.reset(cmd.Process.Pid)
+	c.state = StateStarting
+	programConfig := c.config
+	c.mu.Unlock()
+	err = c.waitReady(programConfig, startedAt, matched, prober)
+	c.mu.Lock()
+
+	if c.cmd != cmd || c.state != StateStarting {
+		// stopped, killed or restarted while waiting.
+		if c.cmd == cmd {
+			c.getWantStop()
+		}
+		err = errors.New("stopped while starting")
+	} else if err != nil {
+		if killErr := c.killAction(); killErr != nil {
+			log.Error("kill program %s %d not started: %s", c.name, cmd.Process.Pid, killErr)
+		}
+		c.state = StateExited
+	}
+	if err != nil {
+		// not waited by c.wait, so reaped here.
+		stat, _ := cmd.Process.Wait()
+		c.lastExit = stat
+		if c.cmd == cmd {
+			c.removeCgroup()
+		}
+		closeLogPipes(cmd)
+		return err
+	}
+
+	if !c.startedAt.IsZero() {
+		c.restarts++
 	}
-	go func() { c.startedCh <- struct{}{} }()
+	c.state = StateRunning
+	c.startedAt = time.Now()
+	c.startHealthCheck()
+	c.startWatchdog()
+	go func() { c.startedCh <- cmd }()
 	return nil
 }
 
-func (c *Controller) wait() {
+func (c *Controller) wait(cmd *exec.Cmd) {
 	var (
 		err  error
 		stat *os.ProcessState
 	)
 	for {
-		stat, err = c.cmd.Process.Wait()
-		if err != nil {
-			log.Warn("wait program %d: %s", c.cmd.Process.Pid, err)
-			if c.running() {
-				continue
-			} else {
-				break
-			}
+		stat, err = cmd.Process.Wait()
+		if err == nil {
+			break
+		}
+		log.Warn("wait program %s %d: %s", c.name, cmd.Process.Pid, err)
+		if !c.lockedRunning() {
+			break
 		}
 	}
-	log.Info("program %d exited with stat: %s", c.cmd.Process.Pid, stat)
-	if err := c.logReadPipe.Close(); err != nil {
-		log.Error("close log pipe reader: %s", err)
-	}
-	if err := c.logWritePipe.Close(); err != nil {
-		log.Error("close log pipe writer: %s", err)
-	}
-	go func() { c.exitedCh <- struct{}{} }()
+	cmd.ProcessState = stat
+	log.Info("program %s %d exited with stat: %s", c.name, cmd.Process.Pid, stat)
+	go func() { c.exitedCh <- cmd }()
 }
 
-func (c *Controller) Stop(_ *Request, _ *Response) error {
+func (c *Controller) Stop(_ *Request, rsp *Response) error {
 	c.setWantStop(1)
-	return c.stopHandler()
+	return c.stopHandler(rsp)
 }
 
-func (c *Controller) stopHandler() (err error) {
+func (c *Controller) stopHandler(rsp *Response) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	log.Info("stopping program %d", c.cmd.Process.Pid)
-	if err = c.stopAction(); err == nil {
-		log.Info("stopped program %d", c.cmd.Process.Pid)
+	log.Info("stopping program %s %d", c.name, c.pid())
+	if err = c.stopAction(rsp); err == nil {
+		if c.state != StateNotStarted {
+			c.state = StateStopped
+		}
+		log.Info("stopped program %s %d", c.name, c.pid())
 	} else {
-		log.Error("stop program %d: %s", c.cmd.Process.Pid, err)
+		log.Error("stop program %s %d: %s", c.name, c.pid(), err)
 	}
 	return
 }
 
-func (c *Controller) stopAction() error {
+// stopAction sends stopSignal to the program and all its descendant processes, and kills them
+// if they are still running after stopTimeout, in which case the program is added to rsp.Forced.
+func (c *Controller) stopAction(rsp *Response) error {
+	c.stopHealthCheck()
+	c.stopWatchdog()
 	if !c.running() {
 		return nil
 	}
-	children, err := c.listChildrenProcesses(c.cmd.Process.Pid)
-	if err != nil {
-		log.Error("failed to list children processes of program %d: %s", c.cmd.Process.Pid, err)
+	sig := c.config.Process.StopSig
+	sigName := config.SignalName(sig)
+	if err := c.signal(sig); err != nil {
+		return err
 	}
-	for _, pid := range children {
-		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
-			return fmt.Errorf("failed to send SIGTERM to grandchild process %d: %s", pid, err)
-		}
-		log.Info("sent SIGTERM to child process %d", pid)
+	stopTimeout := time.Duration(c.config.Process.StopTimeout) * time.Second
+	if c.waitNotRunning(stopTimeout) {
+		return nil
 	}
-	if err := c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
-		return fmt.Errorf("send SIGTERM: %s", err)
+	log.Warn("program %s %d still running %d seconds after %s, escalating to SIGKILL", c.name, c.pid(), c.config.Process.StopTimeout, sigName)
+	if err := c.killAction(); err != nil {
+		return fmt.Errorf("kill after stop timeout: %s", err)
+	}
+	if rsp != nil {
+		rsp.Forced = append(rsp.Forced, c.name)
 	}
-	log.Info("sent SIGTERM to child process %d", c.cmd.Process.Pid)
-	c.waitNotRunning()
 	return nil
 }
 
-func (c *Controller) Restart(_ *Request, _ *Response) (err error) {
+func (c *Controller) Restart(_ *Request, rsp *Response) (err error) {
 	c.mu.Lock()
 	defer func() {
 		c.mu.Unlock()
 		if err == nil {
-			log.Info("restarted program %d", c.cmd.Process.Pid)
+			log.Info("restarted program %s %d", c.name, c.pid())
 		} else {
-			log.Error("restart program %d: %s", c.cmd.Process.Pid, err)
+			log.Error("restart program %s %d: %s", c.name, c.pid(), err)
 		}
 	}()
 	c.setWantStop(0)
-	log.Info("restarting program %d", c.cmd.Process.Pid)
-	if err = c.stopAction(); err != nil {
+	c.backoff.reset()
+	log.Info("restarting program %s %d", c.name, c.pid())
+	if err = c.stopAction(rsp); err != nil {
 		return
 	}
+	if c.state != StateNotStarted {
+		c.state = StateStopped
+	}
 	if err = c.startAction(); err != nil {
 		return
 	}
@@ -203,11 +671,18 @@ func (c *Controller) Restart(_ *Request, _ *Response) (err error) {
 func (c *Controller) Reload(_ *Request, _ *Response) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	log.Info("reloading program %d", c.cmd.Process.Pid)
-	if err = c.cmd.Process.Signal(syscall.SIGHUP); err != nil {
-		log.Error("reload program %d: %s", c.cmd.Process.Pid, err)
+	sig := c.config.Process.ReloadSig
+	if sig == 0 {
+		return errors.New("no reloadSignal configured")
+	}
+	if !c.running() {
+		return errors.New("not running")
+	}
+	log.Info("reloading program %s %d with %s", c.name, c.pid(), config.SignalName(sig))
+	if err = syscall.Kill(c.pid(), sig); err != nil {
+		log.Error("reload program %s %d: %s", c.name, c.pid(), err)
 	} else {
-		log.Info("reloaded program %d", c.cmd.Process.Pid)
+		log.Info("reloaded program %s %d", c.name, c.pid())
 	}
 	return
 }
@@ -217,88 +692,175 @@ func (c *Controller) Kill(_ *Request, _ *Response) (err error) {
 	defer func() {
 		c.mu.Unlock()
 		if err == nil {
-			log.Info("killed program %d", c.cmd.Process.Pid)
+			log.Info("killed program %s %d", c.name, c.pid())
 		} else {
-			log.Error("kill program %d: %s", c.cmd.Process.Pid, err)
+			log.Error("kill program %s %d: %s", c.name, c.pid(), err)
 		}
 	}()
 	c.setWantStop(1)
-	log.Info("killing program %d", c.cmd.Process.Pid)
+	log.Info("killing program %s %d", c.name, c.pid())
+	if err = c.killAction(); err == nil && c.state != StateNotStarted {
+		c.state = StateStopped
+	}
+	return
+}
+
+// killAction sends SIGKILL to the program and all its descendant processes, and waits them to exit.
+func (c *Controller) killAction() error {
+	c.stopHealthCheck()
+	c.stopWatchdog()
 	if c.running() {
-		children, lerr := c.listChildrenProcesses(c.cmd.Process.Pid)
-		if lerr != nil {
-			log.Error("failed to list children processes of program %d: %s", c.cmd.Process.Pid, lerr)
-		}
-		err = c.cmd.Process.Kill()
-		if err != nil {
-			err = fmt.Errorf("failed to kill child process %d: %s", &c.cmd.Process.Pid, err)
-			return
-		}
-		for _, pid := range children {
-			if err = syscall.Kill(pid, syscall.SIGKILL); err != nil {
-				err = fmt.Errorf("failed to kill grand-child process %d: %s", pid, err)
-				return
-			}
-			log.Info("killed child process %d", pid)
+		if err := c.signal(syscall.SIGKILL); err != nil {
+			return err
 		}
 	}
-	c.waitNotRunning()
-	return
+	c.waitNotRunning(0)
+	return nil
 }
 
 func (c *Controller) Status(_ *Request, rsp *Response) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if !c.running() {
-		rsp.Message = "NotStarted\n"
-		return nil
+	st := ProgramStatus{
+		Name:     c.name,
+		State:    c.state,
+		Restarts: c.restarts,
 	}
-	// procfs doc: https://man7.org/linux/man-pages/man5/procfs.5.html
-	pid := c.cmd.Process.Pid
-	statPath := fmt.Sprintf("/proc/%d/stat", pid)
-	statBytes, err := os.ReadFile(statPath)
-	if err != nil {
-		rsp.Message = fmt.Sprintf("failed to read %s: %s", statPath, err)
-		return nil
+	if c.lastExit != nil {
+		st.Exited = true
+		if ws, ok := c.lastExit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			st.LastSignal = config.SignalName(ws.Signal())
+		} else {
+			st.LastExitCode = c.lastExit.ExitCode()
+		}
 	}
-	statFields := bytes.Split(statBytes, []byte(" "))
-	if len(statFields) < 3 {
-		rsp.Message = fmt.Sprintf("want at least 3 proc stat field, got %d", len(statFields))
-		return nil
+	st.OOMKills = c.oomKills
+	if c.cgroup != nil {
+		if stat, err := c.cgroup.Stat(); err == nil {
+			st.Cgroup = stat
+			st.OOMKills += stat.OOMKills
+		}
 	}
-	cmdlinePath := fmt.Sprintf("/proc/%d/cmdline", pid)
-	cmdline, err := os.ReadFile(cmdlinePath)
-	if err != nil {
-		rsp.Message = fmt.Sprintf("failed to read %s: %s", cmdlinePath, err)
+	if c.state == StateStarting || c.state == StateRunning {
+		st.NotifyState = c.notifyState
+		st.NotifyStatus = c.notifyStatus
+	}
+	if c.checker != nil && c.state == StateRunning {
+		hs := c.checker.Status()
+		st.Health = &hs
+	}
+	if c.state == StateBackoff {
+		retryAt := c.retryAt
+		st.RetryTime = &retryAt
+	}
+	if !c.running() {
+		if c.state == StateRunning {
+			// exited but not handled yet
+			st.State = StateExited
+		}
+		rsp.Statuses = append(rsp.Statuses, st)
 		return nil
 	}
-	cmdline = bytes.ReplaceAll(cmdline, []byte{0}, []byte(" "))
-	rsp.Message = fmt.Sprintf("%s %d %s\n", string(statFields[2]), pid, string(cmdline))
-	return nil
-}
 
-func (c *Controller) SupPid(_ *Request, rsp *Response) error {
-	rsp.SupPid = os.Getpid()
+	pid := c.pid()
+	st.Pid = pid
+	st.AttrsConfigured = hasProcessAttrs(&c.config.Process)
+	if c.state != StateStarting {
+		startedAt := c.startedAt
+		st.StartTime = &startedAt
+		st.UptimeSeconds = int64(time.Since(c.startedAt) / time.Second)
+	}
+	var (
+		errs []string
+		err  error
+	)
+	if stat, err := readProcStat(pid); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		st.ProcState = stat.state
+		st.CPUSeconds = stat.cpuTime.Seconds()
+		st.Threads = stat.threads
+		st.RSSBytes = stat.rss
+		st.Nice = stat.nice
+	}
+	if st.Rlimits, err = readProcLimits(pid, rlimitNames); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if st.IOPriority, err = getIOPriority(pid); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if st.Umask, err = readProcUmask(pid); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if st.OOMScoreAdj, err = readProcOOMScoreAdj(pid); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if st.Command, err = readProcCmdline(pid); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if st.FDs, err = countProcFds(pid); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if pids, err := c.processes(); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		for _, p := range pids {
+			if p != pid {
+				st.Children = append(st.Children, p)
+			}
+		}
+	}
+	st.Error = strings.Join(errs, "; ")
+	rsp.Statuses = append(rsp.Statuses, st)
 	return nil
 }
 
-func (c *Controller) running() bool {
+// pid returns the pid of the main process of the last started program, or 0 if it was never started.
+// The main process is the one notified by MAINPID if any, or the one started by sup, or the first remaining
+// process once it exited leaving the others supervised.
+func (c *Controller) pid() int {
+	if c.mainPid > 0 {
+		return c.mainPid
+	}
 	if c.cmd.Process == nil {
-		return false
+		return 0
 	}
-	pids, _ := c.listChildrenProcesses(c.cmd.Process.Pid)
-	for _, pid := range append(pids, c.cmd.Process.Pid) {
-		if isPidRunning(pid) {
-			return true
+	if c.orphaned {
+		if pids, err := c.processes(); err == nil && len(pids) > 0 {
+			sort.Ints(pids)
+			return pids[0]
 		}
 	}
-	return false
+	return c.cmd.Process.Pid
+}
+
+// mainPidRunning reports whether the main process notified by MAINPID is alive, not another one reusing its pid.
+func (c *Controller) mainPidRunning() bool {
+	return c.mainPid > 0 && isProcessRunning(c.mainPid, c.mainStartTime)
+}
+
+func (c *Controller) running() bool {
+	pids, _ := c.processes()
+	return len(pids) > 0
+}
+
+// lockedRunning is running for the caller not holding c.mu, like waiting the program.
+func (c *Controller) lockedRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running()
 }
 
-func (c *Controller) waitNotRunning() {
+// waitNotRunning waits until the program and all its child processes exited, or the timeout if positive.
+// Returns false if they are still running after the timeout.
+func (c *Controller) waitNotRunning(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
 	for {
 		if !c.running() {
-			break
+			return true
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return false
 		}
 		time.Sleep(100 * time.Millisecond)
 	}
@@ -320,37 +882,249 @@ func (c *Controller) getWantExit() bool {
 	return atomic.CompareAndSwapInt32(&c.wantExit, 1, 0)
 }
 
-var reAllDigits = regexp.MustCompile(`^[0-9]+$`)
+// processes returns the pids of the alive processes of the program, which are those in its cgroup if enabled,
+// or those in the process groups of its main processes and the tracked descendants otherwise.
+func (c *Controller) processes() ([]int, error) {
+	var pids []int
+	if c.cgroup != nil {
+		var err error
+		if pids, err = c.cgroup.Procs(); err != nil {
+			return nil, err
+		}
+	} else if pgids := c.processGroups(); len(pgids) > 0 {
+		procs, err := scanProcs()
+		if err != nil {
+			return nil, err
+		}
+		pids = groupProcesses(procs, pgids)
+		for _, pid := range c.descendants.update(procs) {
+			if !containsInt(pids, pid) {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	if pid := c.strayMainPid(); pid > 0 {
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
 
-func (c *Controller) listChildrenProcesses(ppid int) ([]int, error) {
-	var children []int
-	fis, err := os.ReadDir("/proc")
+// hostPid returns the pid seen by sup of the process of the program, whose pid is nsPid in its pid namespace.
+func (c *Controller) hostPid(nsPid int) (int, error) {
+	pids, err := c.processes()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read dir /proc: %s", err)
+		return 0, err
+	}
+	for _, pid := range pids {
+		if p, err := readProcNSpid(pid); err == nil && p == nsPid {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("process %d not found in the pid namespace", nsPid)
+}
+
+// processGroups returns the process groups owned by the program, which are led by the process started by sup,
+// or by the main process notified by MAINPID. The group a main process is merely in may be of others, even of sup.
+// A process group lives as long as any process in it.
+func (c *Controller) processGroups() []int {
+	if c.cmd.Process == nil {
+		return nil
 	}
-	for _, fi := range fis {
-		if fi.IsDir() && reAllDigits.MatchString(filepath.Base(fi.Name())) {
-			pidS := filepath.Base(fi.Name())
-			statusBytes, err := os.ReadFile(filepath.Join("/proc", pidS, "status"))
-			if err != nil {
+	pgids := []int{c.cmd.Process.Pid}
+	if c.mainPid != c.cmd.Process.Pid && c.mainPidRunning() {
+		if pgid, err := syscall.Getpgid(c.mainPid); err == nil && pgid == c.mainPid {
+			pgids = append(pgids, pgid)
+		}
+	}
+	return pgids
+}
+
+// strayMainPid returns the main process notified by MAINPID if it is alive but neither in the cgroup
+// nor in the process groups of the program, or 0 otherwise.
+func (c *Controller) strayMainPid() int {
+	if !c.mainPidRunning() {
+		return 0
+	}
+	if c.cgroup != nil {
+		if pids, err := c.cgroup.Procs(); err == nil && containsInt(pids, c.mainPid) {
+			return 0
+		}
+		return c.mainPid
+	}
+	if pgid, err := syscall.Getpgid(c.mainPid); err == nil && containsInt(c.processGroups(), pgid) {
+		return 0
+	}
+	return c.mainPid
+}
+
+// signal sends sig to all processes of the program.
+func (c *Controller) signal(sig syscall.Signal) error {
+	sigName := config.SignalName(sig)
+	if c.cgroup != nil {
+		var err error
+		if sig == syscall.SIGKILL {
+			err = c.cgroup.Kill()
+		} else {
+			err = c.cgroup.Signal(sig)
+		}
+		if err != nil {
+			return fmt.Errorf("send %s to cgroup %s: %s", sigName, c.cgroup.Path(), err)
+		}
+		log.Info("sent %s to processes in cgroup %s", sigName, c.cgroup.Path())
+	} else {
+		pgids := c.processGroups()
+		for _, pgid := range pgids {
+			if err := syscall.Kill(-pgid, sig); err != nil {
+				if errors.Is(err, syscall.ESRCH) {
+					continue
+				}
+				return fmt.Errorf("send %s to process group %d: %s", sigName, pgid, err)
+			}
+			log.Info("sent %s to process group %d", sigName, pgid)
+		}
+		// the descendants left the process groups, like daemons calling setsid.
+		procs, err := scanProcs()
+		if err != nil {
+			return err
+		}
+		for _, pid := range c.descendants.update(procs) {
+			if containsInt(pgids, procs[pid].pgrp) {
 				continue
 			}
-			for _, line := range strings.Split(string(statusBytes), "\n") {
-				line = strings.ToLower(strings.TrimSpace(line))
-				if strings.HasPrefix(line, "ppid:") {
-					fields := strings.Fields(line)
-					gotPpid, err := strconv.Atoi(fields[len(fields)-1])
-					if err != nil {
-						continue
-					}
-					if gotPpid == ppid {
-						pid, _ := strconv.Atoi(pidS)
-						children = append(children, pid)
-					}
-					break
-				}
+			if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return fmt.Errorf("send %s to descendant process %d: %s", sigName, pid, err)
 			}
+			log.Info("sent %s to descendant process %d", sigName, pid)
 		}
 	}
-	return children, nil
+	if pid := c.strayMainPid(); pid > 0 {
+		if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("send %s to main process %d: %s", sigName, pid, err)
+		}
+		log.Info("sent %s to main process %d", sigName, pid)
+	}
+	return nil
+}
+
+// intoCgroup makes cmd spawned right in the cgroup of the program, which is created if not existing,
+// so no descendant could escape by forking before moved. The returned cgroup directory should be closed
+// after cmd started. The caller must hold c.mu.
+func (c *Controller) intoCgroup(cmd *exec.Cmd) (*os.File, error) {
+	cg, err := cgroup.New(filepath.Join(c.config.Cgroup.Parent, c.name))
+	if err != nil {
+		return nil, err
+	}
+	if err := applyResources(cg, &c.config.Resources); err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(cg.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup %s: %s", cg.Path(), err)
+	}
+	// SysProcAttr is shared with the template cmd.
+	attr := *cmd.SysProcAttr
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(dir.Fd())
+	cmd.SysProcAttr = &attr
+	c.cgroup = cg
+	return dir, nil
+}
+
+// removeCgroup removes the cgroup of the program exited. The caller must hold c.mu.
+func (c *Controller) removeCgroup() {
+	if c.cgroup == nil {
+		return
+	}
+	if stat, err := c.cgroup.Stat(); err == nil && stat.OOMKills > 0 {
+		log.Warn("%d processes of program %s were OOM killed", stat.OOMKills, c.name)
+		c.oomKills += stat.OOMKills
+	}
+	if err := c.cgroup.Remove(); err != nil {
+		log.Error("remove cgroup of program %s: %s", c.name, err)
+	}
+	c.cgroup = nil
+}
+
+// spawnAttr returns the attributes applied to the program before executed.
+func (c *Controller) spawnAttr() *spawn.Attr {
+	pc := &c.config.Process
+	attr := &spawn.Attr{}
+	for _, rlimit := range pc.Rlimits.Parsed {
+		attr.Rlimits = append(attr.Rlimits, spawn.Rlimit{Resource: rlimit.Resource, Cur: rlimit.Cur, Max: rlimit.Max})
+	}
+	if pc.Nice != 0 {
+		attr.Nice = &pc.Nice
+	}
+	if class, ok := config.IOClasses[pc.IOClass]; ok {
+		ioPriority := spawn.IOPriority(class, pc.IOPriority)
+		attr.IOPriority = &ioPriority
+	}
+	if pc.UmaskBits >= 0 {
+		attr.Umask = &pc.UmaskBits
+	}
+	if pc.OOMScoreAdj != 0 {
+		attr.OOMScoreAdj = &pc.OOMScoreAdj
+	}
+	attr.Cloneflags = pc.Cloneflags
+	attr.PrivateTmp = pc.PrivateTmp
+	attr.ReadOnlyPaths = pc.ReadOnlyPaths
+	attr.BoundingCaps = pc.Capabilities.BoundingCaps
+	attr.NoNewPrivileges = pc.NoNewPrivileges
+	return attr
+}
+
+// applyResources writes the resource limits into the cgroup.
+func applyResources(cg *cgroup.Cgroup, r *config.Resources) error {
+	limits := []struct {
+		file  string
+		value string
+		set   bool
+	}{
+		{"memory.max", strconv.FormatInt(r.MemoryMaxBytes, 10), r.MemoryMaxBytes > 0},
+		{"memory.high", strconv.FormatInt(r.MemoryHighBytes, 10), r.MemoryHighBytes > 0},
+		{"cpu.max", r.CPUMax, len(r.CPUMax) > 0},
+		{"pids.max", strconv.Itoa(r.PidsMax), r.PidsMax > 0},
+		{"io.weight", "default " + strconv.Itoa(r.IOWeight), r.IOWeight > 0},
+	}
+	for _, limit := range limits {
+		if !limit.set {
+			continue
+		}
+		if err := cg.Set(limit.file, limit.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasProcessAttrs reports whether any of rlimits, nice, ionice, umask and oom_score_adj is configured.
+func hasProcessAttrs(p *config.Process) bool {
+	return len(p.Rlimits.Parsed) > 0 || p.Nice != 0 || p.IOClass != config.IOClassNone || p.UmaskBits >= 0 || p.OOMScoreAdj != 0
+}
+
+// groupProcesses returns the alive processes in the given process groups.
+func groupProcesses(procs map[int]*procStat, pgids []int) []int {
+	var pids []int
+	for pid, stat := range procs {
+		if stat.state != "Z" && containsInt(pgids, stat.pgrp) {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// isProcessRunning reports whether the process started at startTime is alive, not another one reusing its pid.
+func isProcessRunning(pid int, startTime int64) bool {
+	stat, err := readProcStat(pid)
+	return err == nil && stat.state != "Z" && stat.startTime == startTime
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
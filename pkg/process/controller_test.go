@@ -0,0 +1,34 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestControllerAutoStartRetried checks the program failed to start as sup goes up is restarted with backoff.
+func TestControllerAutoStartRetried(t *testing.T) {
+	dir := t.TempDir()
+	c := runController(t, dir, `
+[programs.a.process]
+path = "/bin/sh"
+args = ["-c", "[ -e %[1]s/failed ] || { touch %[1]s/failed; exit 1; }; echo $$ > %[1]s/pid; exec sleep 30"]
+autoStart = true
+startSeconds = 1
+[programs.a.process.backoff]
+initialSeconds = 1
+[programs.a.log]
+path = "%[1]s/a.log"
+`)
+	pid := readPid(t, filepath.Join(dir, "pid"))
+	if _, err := os.Stat(filepath.Join(dir, "failed")); err != nil {
+		t.Fatalf("not failed before: %s", err)
+	}
+	waitUntil(t, "running after retried", func() bool {
+		st := status(t, c)
+		return st.State == StateRunning && st.Pid == pid
+	})
+	if err := c.Stop(nil, &Response{}); err != nil {
+		t.Fatal(err)
+	}
+}
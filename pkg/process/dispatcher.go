@@ -0,0 +1,98 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sequix/sup/pkg/config"
+)
+
+// Dispatcher serves the rpc requests by forwarding them to the controllers of the requested programs.
+type Dispatcher struct {
+	controllers map[string]*Controller
+	names       []string
+}
+
+type action func(c *Controller, req *Request, rsp *Response) error
+
+func (d *Dispatcher) Start(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Start)
+}
+
+func (d *Dispatcher) Stop(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Stop)
+}
+
+func (d *Dispatcher) Restart(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Restart)
+}
+
+func (d *Dispatcher) Reload(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Reload)
+}
+
+func (d *Dispatcher) Kill(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Kill)
+}
+
+func (d *Dispatcher) Status(req *Request, rsp *Response) error {
+	return d.dispatch(req, rsp, (*Controller).Status)
+}
+
+func (d *Dispatcher) SupPid(_ *Request, rsp *Response) error {
+	rsp.SupPid = os.Getpid()
+	return nil
+}
+
+// dispatch runs the action on every requested program concurrently, and merges their responses in name order.
+func (d *Dispatcher) dispatch(req *Request, rsp *Response, act action) error {
+	controllers, err := d.targets(req.Program)
+	if err != nil {
+		return err
+	}
+	var (
+		wg   sync.WaitGroup
+		rsps = make([]Response, len(controllers))
+		errs = make([]error, len(controllers))
+	)
+	for i, c := range controllers {
+		wg.Add(1)
+		go func(i int, c *Controller) {
+			defer wg.Done()
+			errs[i] = act(c, req, &rsps[i])
+		}(i, c)
+	}
+	wg.Wait()
+
+	var errMsgs []string
+	for i, c := range controllers {
+		rsp.Message += rsps[i].Message
+		rsp.Forced = append(rsp.Forced, rsps[i].Forced...)
+		rsp.Statuses = append(rsp.Statuses, rsps[i].Statuses...)
+		if errs[i] != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s: %s", c.name, errs[i]))
+		}
+	}
+	if len(errMsgs) > 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+	return nil
+}
+
+func (d *Dispatcher) targets(program string) ([]*Controller, error) {
+	if len(program) == 0 || program == config.AllPrograms {
+		controllers := make([]*Controller, 0, len(d.names))
+		for _, name := range d.names {
+			controllers = append(controllers, d.controllers[name])
+		}
+		return controllers, nil
+	}
+	c, ok := d.controllers[program]
+	if !ok {
+		return nil, fmt.Errorf("unknown program %q, want one of %v or %q", program, d.names, config.AllPrograms)
+	}
+	return []*Controller{c}, nil
+}
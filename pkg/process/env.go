@@ -0,0 +1,154 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sequix/sup/pkg/config"
+)
+
+// environ returns the env of the program for a start, which is the base env overridden by those in envFiles
+// read right now, then by envs, and then by those set by sup like NOTIFY_SOCKET. The secrets passed as
+// file descriptors are returned as files, which are the extra files of the cmd and should be closed after started.
+// The caller must hold c.mu.
+func (c *Controller) environ() ([]string, []*os.File, error) {
+	pc := &c.config.Process
+	env := make(map[string]string, len(c.baseEnv)+len(pc.Envs))
+	for k, v := range c.baseEnv {
+		env[k] = v
+	}
+	lookup := func(name string) string { return env[name] }
+	for _, filename := range pc.EnvFiles {
+		vars, err := config.ReadEnvFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range vars {
+			if !v.Literal {
+				v.Value = config.ExpandEnv(v.Value, lookup)
+			}
+			env[v.Name] = v.Value
+		}
+	}
+
+	names := make([]string, 0, len(pc.Envs))
+	for name := range pc.Envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// secrets are taken literally, and could be referred to by other envs.
+	var files []*os.File
+	secrets := make(map[string]string)
+	for _, name := range names {
+		value := pc.Envs[name]
+		if !value.Secret() {
+			continue
+		}
+		f, err := openSecret(value.File)
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("secret env %s: %s", name, err)
+		}
+		if value.FD {
+			// the fd of ExtraFiles[i] is 3+i.
+			secrets[name] = strconv.Itoa(3 + len(files))
+			files = append(files, f)
+			continue
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("secret env %s: failed to read %s: %s", name, value.File, err)
+		}
+		secrets[name] = strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+	}
+
+	// envs may refer to each other, a variable referring to itself like PATH gets the value overridden.
+	var (
+		expanded  = make(map[string]string, len(pc.Envs))
+		expanding = make(map[string]bool, len(pc.Envs))
+		expand    func(name string) string
+	)
+	expand = func(name string) string {
+		if v, ok := expanded[name]; ok {
+			return v
+		}
+		if v, ok := secrets[name]; ok {
+			return v
+		}
+		raw, ok := pc.Envs[name]
+		if !ok || expanding[name] {
+			return env[name]
+		}
+		expanding[name] = true
+		v := config.ExpandEnv(raw.Value, expand)
+		expanding[name] = false
+		expanded[name] = v
+		return v
+	}
+	for _, name := range names {
+		expand(name)
+	}
+	for k, v := range expanded {
+		env[k] = v
+	}
+	for k, v := range secrets {
+		env[k] = v
+	}
+
+	if c.config.Notify.Enabled {
+		// the socket is inside the chroot if given.
+		env["NOTIFY_SOCKET"] = strings.TrimPrefix(c.config.Notify.Socket, pc.Chroot)
+		if c.config.Notify.WatchdogSeconds > 0 {
+			env["WATCHDOG_USEC"] = strconv.Itoa(c.config.Notify.WatchdogSeconds * 1000000)
+		} else {
+			delete(env, "WATCHDOG_USEC")
+		}
+	}
+	envs := make([]string, 0, len(env))
+	for k, v := range env {
+		envs = append(envs, k+"="+v)
+	}
+	sort.Strings(envs)
+	return envs, files, nil
+}
+
+// openSecret opens the secret file, which has to be a regular file owned by root or the user of sup,
+// and not accessible by others or writable by the group.
+func openSecret(filename string) (*os.File, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret file: %s", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat secret file: %s", err)
+	}
+	var reason string
+	if !fi.Mode().IsRegular() {
+		reason = "not a regular file"
+	} else if fi.Mode().Perm()&0027 != 0 {
+		reason = fmt.Sprintf("mode %#o accessible by others or writable by group", fi.Mode().Perm())
+	} else if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != os.Getuid() {
+		reason = fmt.Sprintf("owned by uid %d", st.Uid)
+	}
+	if len(reason) > 0 {
+		f.Close()
+		return nil, fmt.Errorf("insecure secret file %s: %s", filename, reason)
+	}
+	return f, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
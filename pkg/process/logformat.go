@@ -0,0 +1,105 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// lineFormat is how each line of the output is written to the log.
+type lineFormat struct {
+	// json wraps each line into a JSON object, otherwise the line is prefixed as configured.
+	json    bool
+	program string
+	// timeFormat is empty if the time not prefixed, or RFC 3339 for json.
+	timeFormat string
+	timeUTC    bool
+	stream     bool
+	pid        bool
+}
+
+// append appends the line of the stream started at t, without the newline, in the format to b with a newline.
+// tag is prefixed to the line of text.
+func (f *lineFormat) append(b []byte, t time.Time, stream string, pid int, tag string, line []byte, partial bool) []byte {
+	if f.timeUTC {
+		t = t.UTC()
+	}
+	if f.json {
+		return append(f.appendJSON(b, t, stream, pid, line, partial), '\n')
+	}
+	if len(f.timeFormat) > 0 {
+		b = append(t.AppendFormat(b, f.timeFormat), ' ')
+	}
+	if f.stream {
+		b = append(append(b, stream...), ' ')
+	}
+	if f.pid && pid > 0 {
+		b = append(strconv.AppendInt(b, int64(pid), 10), ' ')
+	}
+	b = append(append(b, tag...), line...)
+	return append(b, '\n')
+}
+
+// appendJSON appends {"ts":..., "stream":..., "pid":..., "program":..., "msg":...} of the line. The fields of a line
+// of JSON object are kept as is, to which those above not in it are added except msg. The line not valid UTF-8 has
+// the invalid bytes replaced by U+FFFD in msg.
+func (f *lineFormat) appendJSON(b []byte, t time.Time, stream string, pid int, line []byte, partial bool) []byte {
+	var (
+		object  map[string]json.RawMessage
+		trimmed = bytes.TrimSpace(line)
+	)
+	if partial || !bytes.HasPrefix(trimmed, []byte("{")) || json.Unmarshal(trimmed, &object) != nil {
+		object = nil
+	}
+	has := func(key string) bool {
+		_, ok := object[key]
+		return ok
+	}
+
+	timeFormat := f.timeFormat
+	if len(timeFormat) == 0 {
+		timeFormat = time.RFC3339Nano
+	}
+	b = append(b, '{')
+	if !has("ts") {
+		b = appendJSONString(append(b, `"ts":`...), t.Format(timeFormat))
+		b = append(b, ',')
+	}
+	if !has("stream") {
+		b = appendJSONString(append(b, `"stream":`...), stream)
+		b = append(b, ',')
+	}
+	if pid > 0 && !has("pid") {
+		b = append(strconv.AppendInt(append(b, `"pid":`...), int64(pid), 10), ',')
+	}
+	if !has("program") {
+		b = appendJSONString(append(b, `"program":`...), f.program)
+		b = append(b, ',')
+	}
+	if object == nil {
+		if partial {
+			b = append(b, `"partial":true,`...)
+		}
+		b = appendJSONString(append(b, `"msg":`...), string(line))
+		return append(b, '}')
+	}
+	// the fields of the line follow, compacted into a line.
+	var compacted bytes.Buffer
+	_ = json.Compact(&compacted, trimmed)
+	fields := bytes.TrimSpace(compacted.Bytes()[1:])
+	if bytes.Equal(fields, []byte("}")) {
+		// no fields in the line, drops the trailing comma.
+		return append(b[:len(b)-1], '}')
+	}
+	return append(b, fields...)
+}
+
+// appendJSONString appends s quoted as a JSON string, in which <, > and & are not escaped.
+func appendJSONString(b []byte, s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return append(b, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
+}
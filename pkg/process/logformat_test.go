@@ -0,0 +1,124 @@
+package process
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLineFormatAppend(t *testing.T) {
+	at := time.Date(2026, 10, 17, 18, 30, 5, 0, time.FixedZone("UTC+8", 8*60*60))
+	tests := []struct {
+		name   string
+		format lineFormat
+		pid    int
+		tag    string
+		want   string
+	}{
+		{"as is", lineFormat{}, 42, "", "hello\n"},
+		{"tag", lineFormat{}, 42, "[stderr] ", "[stderr] hello\n"},
+		{"time", lineFormat{timeFormat: "15:04:05"}, 42, "", "18:30:05 hello\n"},
+		{"time in UTC", lineFormat{timeFormat: "15:04:05", timeUTC: true}, 42, "", "10:30:05 hello\n"},
+		{"stream", lineFormat{stream: true}, 42, "", "stderr hello\n"},
+		{"pid", lineFormat{pid: true}, 42, "", "42 hello\n"},
+		{"pid unknown", lineFormat{pid: true}, 0, "", "hello\n"},
+		{
+			name:   "time, stream, pid and tag in order",
+			format: lineFormat{timeFormat: "2006-01-02T15:04:05Z07:00", stream: true, pid: true},
+			pid:    42,
+			tag:    "[stderr] ",
+			want:   "2026-10-17T18:30:05+08:00 stderr 42 [stderr] hello\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.format.append([]byte("kept "), at, "stderr", tt.pid, tt.tag, []byte("hello"), false)
+			if want := "kept " + tt.want; string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLineFormatAppendJSON(t *testing.T) {
+	at := time.Date(2026, 10, 17, 10, 30, 5, 123000000, time.UTC)
+	const fields = `"ts":"2026-10-17T10:30:05Z","stream":"stdout","pid":42,"program":"x"`
+	tests := []struct {
+		name    string
+		format  lineFormat
+		pid     int
+		line    string
+		partial bool
+		want    string
+	}{
+		{"text", lineFormat{}, 42, "hello world", false, `{` + fields + `,"msg":"hello world"}`},
+		{"empty", lineFormat{}, 42, "", false, `{` + fields + `,"msg":""}`},
+		{"pid unknown", lineFormat{}, 0, "a", false, `{"ts":"2026-10-17T10:30:05Z","stream":"stdout","program":"x","msg":"a"}`},
+		{
+			name:   "time in RFC 3339 by default",
+			format: lineFormat{timeFormat: "-"},
+			pid:    42,
+			line:   "a",
+			want:   `{"ts":"2026-10-17T10:30:05.123Z","stream":"stdout","pid":42,"program":"x","msg":"a"}`,
+		},
+		{"object merged", lineFormat{}, 42, `{"level":"info","msg":"hi"}`, false, `{` + fields + `,"level":"info","msg":"hi"}`},
+		{
+			name: "object compacted",
+			line: " {\t\"level\" : \"info\",  \"n\": [1, 2] } ",
+			pid:  42,
+			want: `{` + fields + `,"level":"info","n":[1,2]}`,
+		},
+		{
+			name: "object with the fields kept",
+			line: `{"ts":"then","stream":"mine","pid":1,"program":"other","msg":"m"}`,
+			pid:  42,
+			want: `{"ts":"then","stream":"mine","pid":1,"program":"other","msg":"m"}`,
+		},
+		{
+			name: "object with some fields kept",
+			line: `{"pid":"worker-1","msg":"m"}`,
+			pid:  42,
+			want: `{"ts":"2026-10-17T10:30:05Z","stream":"stdout","program":"x","pid":"worker-1","msg":"m"}`,
+		},
+		{"empty object", lineFormat{}, 42, `{}`, false, `{` + fields + `}`},
+		{"empty object with spaces", lineFormat{}, 42, `{ }`, false, `{` + fields + `}`},
+		{"array", lineFormat{}, 42, `[1,2]`, false, `{` + fields + `,"msg":"[1,2]"}`},
+		{"string", lineFormat{}, 42, `"s"`, false, `{` + fields + `,"msg":"\"s\""}`},
+		{"number", lineFormat{}, 42, `42`, false, `{` + fields + `,"msg":"42"}`},
+		{"null", lineFormat{}, 42, `null`, false, `{` + fields + `,"msg":"null"}`},
+		{"invalid object", lineFormat{}, 42, `{"a":`, false, `{` + fields + `,"msg":"{\"a\":"}`},
+		{"object followed by text", lineFormat{}, 42, `{"a":1} b`, false, `{` + fields + `,"msg":"{\"a\":1} b"}`},
+		{"partial", lineFormat{}, 42, "unfinis", true, `{` + fields + `,"partial":true,"msg":"unfinis"}`},
+		{"partial object not merged", lineFormat{}, 42, `{"a":1}`, true, `{` + fields + `,"partial":true,"msg":"{\"a\":1}"}`},
+		{
+			name: "control characters escaped",
+			line: "a\tb\r\x00\x1b[31mc\x7f",
+			pid:  42,
+			want: `{` + fields + `,"msg":"a\tb\r\u0000\u001b[31mc` + "\x7f" + `"}`,
+		},
+		{"invalid UTF-8 replaced", lineFormat{}, 42, "a\xffb\xc3", false, `{` + fields + `,"msg":"a�b�"}`},
+		{"HTML not escaped", lineFormat{}, 42, "<a href='x'>&</a>", false, `{` + fields + `,"msg":"<a href='x'>&</a>"}`},
+		{"unicode kept", lineFormat{}, 42, "日本", false, `{` + fields + `,"msg":"日本"}`},
+		{"line separators escaped", lineFormat{}, 42, "a\u2028b\u2029", false, `{` + fields + `,"msg":"a\u2028b\u2029"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.format
+			f.json, f.program = true, "x"
+			// the time without fraction unless testing the default, given by "-".
+			switch f.timeFormat {
+			case "":
+				f.timeFormat = time.RFC3339
+			case "-":
+				f.timeFormat = ""
+			}
+			got := f.append(nil, at, "stdout", tt.pid, "ignored ", []byte(tt.line), tt.partial)
+			if want := tt.want + "\n"; string(got) != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+			if !json.Valid(got) {
+				t.Errorf("got invalid JSON %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package process
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/log"
+	"github.com/sequix/sup/pkg/rotate"
+)
+
+// maxLogLineBytes is the maximum bytes of a line of the output, longer lines are split.
+const maxLogLineBytes = 64 * 1024
+
+// loggers are the log files of a program. stdout and stderr are nil if written to combined, which is nil if
+// neither is.
+type loggers struct {
+	combined *rotate.FileWriter
+	stdout   *rotate.FileWriter
+	stderr   *rotate.FileWriter
+	format   lineFormat
+	// stderrTag prefixes each line of stderr written to combined.
+	stderrTag string
+	// pipes are the output being copied to the log files.
+	pipes sync.WaitGroup
+}
+
+func newLoggers(programConfig *config.Program) (*loggers, error) {
+	var (
+		logConfig = &programConfig.Log
+		l         = &loggers{
+			format: lineFormat{
+				json:       logConfig.Format == config.LogFormatJSON,
+				program:    programConfig.Name,
+				timeFormat: logConfig.TimeFormat,
+				timeUTC:    logConfig.TimeUTC,
+				stream:     logConfig.PrefixStream,
+				pid:        logConfig.PrefixPid,
+			},
+			stderrTag: logConfig.StderrTag,
+		}
+		err error
+	)
+	if len(logConfig.Stdout.Path) > 0 {
+		if l.stdout, err = newLogger(&logConfig.Stdout); err != nil {
+			return nil, fmt.Errorf("init stdout rotate logger of program %s: %s", programConfig.Name, err)
+		}
+	}
+	if len(logConfig.Stderr.Path) > 0 {
+		if l.stderr, err = newLogger(&logConfig.Stderr); err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("init stderr rotate logger of program %s: %s", programConfig.Name, err)
+		}
+	}
+	if l.stdout == nil || l.stderr == nil {
+		combined := config.LogFile{
+			Path:            logConfig.Path,
+			MaxSize:         logConfig.MaxSize,
+			MaxDays:         logConfig.MaxDays,
+			MaxBackups:      logConfig.MaxBackups,
+			Compress:        logConfig.Compress,
+			MergeCompressed: logConfig.MergeCompressed,
+			RotateSchedule:  logConfig.RotateSchedule,
+		}
+		if l.combined, err = newLogger(&combined); err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("init rotate logger of program %s: %s", programConfig.Name, err)
+		}
+	}
+	return l, nil
+}
+
+func newLogger(logConfig *config.LogFile) (*rotate.FileWriter, error) {
+	var schedule *rotate.Schedule
+	if len(logConfig.RotateSchedule) > 0 {
+		var err error
+		if schedule, err = rotate.ParseSchedule(logConfig.RotateSchedule); err != nil {
+			return nil, err
+		}
+	}
+	return rotate.NewFileWriter(
+		rotate.WithFilename(logConfig.Path),
+		rotate.WithSchedule(schedule),
+		rotate.WithMaxBytes(int64(logConfig.MaxSize)*1024*1024),
+		rotate.WithMaxBackups(logConfig.MaxBackups),
+		rotate.WithCompress(logConfig.Compress),
+		rotate.WithMergeCompressedBackups(logConfig.MergeCompressed),
+		rotate.WithMaxAge(time.Hour*24*time.Duration(logConfig.MaxDays)),
+	)
+}
+
+// Close closes the log files. A log file written after closed is reopened.
+func (l *loggers) Close() (err error) {
+	for _, w := range []*rotate.FileWriter{l.combined, l.stdout, l.stderr} {
+		if w == nil {
+			continue
+		}
+		if closeErr := w.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// closeDrained closes the log files in background after the output being copied to them is drained.
+func (l *loggers) closeDrained(name string) {
+	go func() {
+		l.pipes.Wait()
+		if err := l.Close(); err != nil {
+			log.Error("close rotate logger of program %s: %s", name, err)
+		}
+	}()
+}
+
+// pipe sets the stdout and stderr of cmd to pipes, from which the output is copied to the log files until they are
+// closed by closeLogPipes. The output is written to matcher too if not nil.
+func (l *loggers) pipe(name string, cmd *exec.Cmd, matcher *lineMatcher) {
+	if l.stdout == nil && l.stderr == nil && !l.format.stream && !l.format.json && len(l.stderrTag) == 0 {
+		// a single pipe keeps the order of the output of both, whose lines need not be told apart.
+		w := l.pipeLog(name, l.writer(l.combined, "", "", cmd), matcher)
+		cmd.Stdout, cmd.Stderr = w, w
+		return
+	}
+	var (
+		stdout, stderr io.Writer = l.combined, l.combined
+		stderrTag                = l.stderrTag
+	)
+	if l.stdout != nil {
+		stdout = l.stdout
+	}
+	if l.stderr != nil {
+		stderr, stderrTag = l.stderr, ""
+	}
+	cmd.Stdout = l.pipeLog(name, l.writer(stdout, "stdout", "", cmd), matcher)
+	cmd.Stderr = l.pipeLog(name, l.writer(stderr, "stderr", stderrTag, cmd), matcher.fork())
+}
+
+// writer returns a lineWriter writing the stream of cmd to dst, or dst itself if written as is.
+func (l *loggers) writer(dst io.Writer, stream, tag string, cmd *exec.Cmd) io.Writer {
+	f := l.format
+	if !f.json && len(f.timeFormat) == 0 && !f.stream && !f.pid && len(tag) == 0 {
+		return dst
+	}
+	return &lineWriter{w: dst, format: &f, stream: stream, tag: tag, cmd: cmd}
+}
+
+// pipeLog returns a pipe, from which the output is copied to dst and matcher in background until it is closed.
+func (l *loggers) pipeLog(name string, dst io.Writer, matcher *lineMatcher) *io.PipeWriter {
+	lw, _ := dst.(*lineWriter)
+	if matcher != nil {
+		dst = io.MultiWriter(dst, matcher)
+	}
+	r, w := io.Pipe()
+	l.pipes.Add(1)
+	go func() {
+		defer l.pipes.Done()
+		written, err := io.Copy(dst, r)
+		if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Error("stopped logger harvest of program %s, written %d bytes, err %s", name, written, err)
+		}
+		if lw != nil {
+			if err := lw.Flush(); err != nil {
+				log.Error("flush the last line of program %s: %s", name, err)
+			}
+		}
+	}()
+	return w
+}
+
+// closeLogPipes closes the pipes from which the output of cmd is copied to the log.
+func closeLogPipes(cmd *exec.Cmd) {
+	for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+		if closer, ok := w.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+// lineWriter writes each line of a stream of cmd to w at once in the format, so that the lines of streams written to
+// the same w are not mixed. Lines longer than maxLogLineBytes are split.
+type lineWriter struct {
+	w      io.Writer
+	format *lineFormat
+	stream string
+	tag    string
+	cmd    *exec.Cmd
+	// line is the unfinished line, empty if none.
+	line []byte
+	// at is when the unfinished line started.
+	at time.Time
+	// buf is the line formatted.
+	buf []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(lw.line) == 0 {
+			lw.at = time.Now()
+		}
+		i := bytes.IndexByte(p, '\n')
+		end := len(p)
+		if i >= 0 {
+			end = i
+		}
+		if room := maxLogLineBytes - len(lw.line); end > room {
+			lw.line = append(lw.line, p[:room]...)
+			p = p[room:]
+			if err := lw.writeLine(true); err != nil {
+				return n - len(p), err
+			}
+			continue
+		}
+		lw.line = append(lw.line, p[:end]...)
+		p = p[end:]
+		if i >= 0 {
+			p = p[1:]
+			if err := lw.writeLine(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the unfinished line.
+func (lw *lineWriter) Flush() error {
+	if len(lw.line) == 0 {
+		return nil
+	}
+	return lw.writeLine(true)
+}
+
+// writeLine writes the line without the newline, which is partial if split or unfinished.
+func (lw *lineWriter) writeLine(partial bool) error {
+	pid := 0
+	if lw.cmd.Process != nil {
+		pid = lw.cmd.Process.Pid
+	}
+	lw.buf = lw.format.append(lw.buf[:0], lw.at, lw.stream, pid, lw.tag, lw.line, partial)
+	lw.line = lw.line[:0]
+	_, err := lw.w.Write(lw.buf)
+	return err
+}
@@ -0,0 +1,198 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+)
+
+// TestLoggersCloseDrained checks the log file is closed only after the output being copied to it is drained.
+func TestLoggersCloseDrained(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "x.log")
+	l, err := newLoggers(&config.Program{Name: "x", Log: config.Log{Path: filename, MaxSize: 1, RotateSchedule: "hourly"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &exec.Cmd{}
+	l.pipe("x", cmd, nil)
+	l.closeDrained("x")
+
+	if _, err := io.WriteString(cmd.Stdout, "before drained\n"); err != nil {
+		t.Fatal(err)
+	}
+	// the write to the pipe returns once read, maybe before written to the file.
+	waitUntil(t, "the output written", func() bool {
+		content, _ := os.ReadFile(filename)
+		return string(content) == "before drained\n"
+	})
+	if !isFileOpen(t, filename) {
+		t.Fatalf("%s closed before the output drained", filename)
+	}
+	closeLogPipes(cmd)
+	waitUntil(t, "the log file closed", func() bool { return !isFileOpen(t, filename) })
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("not %s in 5s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isFileOpen reports whether the file is opened by the test process.
+func isFileOpen(t *testing.T, filename string) bool {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if target, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%s", fd.Name())); err == nil && target == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// lineRecorder records each write.
+type lineRecorder struct {
+	writes []string
+}
+
+func (r *lineRecorder) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func TestLineWriter(t *testing.T) {
+	long := strings.Repeat("x", maxLogLineBytes)
+	tests := []struct {
+		name   string
+		writes []string
+		flush  bool
+		// want is the lines written each at once.
+		want []string
+	}{
+		{
+			name:   "lines in a write",
+			writes: []string{"a\nb\n\n"},
+			want:   []string{"out a\n", "out b\n", "out \n"},
+		},
+		{
+			name:   "line across writes",
+			writes: []string{"he", "llo", " world\nne", "xt\n"},
+			want:   []string{"out hello world\n", "out next\n"},
+		},
+		{
+			name:   "unfinished line not written until flushed",
+			writes: []string{"a\nb"},
+			want:   []string{"out a\n"},
+		},
+		{
+			name:   "unfinished line flushed",
+			writes: []string{"a\nb"},
+			flush:  true,
+			want:   []string{"out a\n", "out b\n"},
+		},
+		{
+			name:   "nothing to flush",
+			writes: []string{"a\n"},
+			flush:  true,
+			want:   []string{"out a\n"},
+		},
+		{
+			name:   "line of maxLogLineBytes",
+			writes: []string{long + "\n"},
+			want:   []string{"out " + long + "\n"},
+		},
+		{
+			name:   "line longer than maxLogLineBytes",
+			writes: []string{long + "yyy\n"},
+			want:   []string{"out " + long + "\n", "out yyy\n"},
+		},
+		{
+			name:   "line longer than maxLogLineBytes across writes",
+			writes: []string{long[1:], "yy", "y\nz"},
+			flush:  true,
+			want:   []string{"out " + long[1:] + "y\n", "out yy\n", "out z\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				r  lineRecorder
+				lw = &lineWriter{w: &r, format: &lineFormat{}, tag: "out ", cmd: &exec.Cmd{}}
+			)
+			for _, s := range tt.writes {
+				if n, err := lw.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("write %q got %d, %v", s, n, err)
+				}
+			}
+			if tt.flush {
+				if err := lw.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if fmt.Sprintf("%q", r.writes) != fmt.Sprintf("%q", tt.want) {
+				t.Errorf("got %q, want %q", r.writes, tt.want)
+			}
+		})
+	}
+}
+
+// TestLineWriterFlushPartial checks the unfinished line flushed, and the line split, are marked partial.
+func TestLineWriterFlushPartial(t *testing.T) {
+	var (
+		r   lineRecorder
+		cmd = &exec.Cmd{Process: &os.Process{Pid: 42}}
+		lw  = &lineWriter{w: &r, format: &lineFormat{json: true, program: "x"}, stream: "stdout", cmd: cmd}
+	)
+	long := strings.Repeat("x", maxLogLineBytes)
+	for _, s := range []string{"a\n", long + "y\n", "unfinished"} {
+		if _, err := lw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		msg     string
+		partial bool
+	}{
+		{"a", false},
+		{long, true},
+		{"y", false},
+		{"unfinished", true},
+	}
+	if len(r.writes) != len(want) {
+		t.Fatalf("got %d lines %q, want %d", len(r.writes), r.writes, len(want))
+	}
+	for i, w := range want {
+		var line struct {
+			Stream  string `json:"stream"`
+			Pid     int    `json:"pid"`
+			Program string `json:"program"`
+			Partial bool   `json:"partial"`
+			Msg     string `json:"msg"`
+		}
+		if err := json.Unmarshal([]byte(r.writes[i]), &line); err != nil {
+			t.Fatalf("line %d %q: %s", i, r.writes[i], err)
+		}
+		if line.Msg != w.msg || line.Partial != w.partial || line.Stream != "stdout" || line.Pid != 42 || line.Program != "x" {
+			t.Errorf("got line %d %q, want msg %.16q partial %t", i, r.writes[i], w.msg, w.partial)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sequix/sup/pkg/log"
+)
+
+// sd_notify doc: https://www.freedesktop.org/software/systemd/man/sd_notify.html
+
+// maxNotifyMessageBytes is the maximum size of a notify message, longer ones are truncated.
+const maxNotifyMessageBytes = 64 * 1024
+
+// notifier receives the sd_notify messages sent by a program to its unix datagram socket.
+type notifier struct {
+	path   string
+	conn   *net.UnixConn
+	handle func(msg map[string]string)
+}
+
+// newNotifier listens on the socket path, which is writable by the given uid and gid only, besides root.
+// uid or gid being -1 is left unchanged.
+func newNotifier(path string, uid, gid int, handle func(msg map[string]string)) (*notifier, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %s", filepath.Dir(path), err)
+	}
+	if err := removeNotUsingSocket(path); err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("listen to notify socket %q: %s", path, err)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chown notify socket %q: %s", path, err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("chmod notify socket %q: %s", path, err)
+	}
+	return &notifier{
+		path:   path,
+		conn:   conn,
+		handle: handle,
+	}, nil
+}
+
+func (n *notifier) run(stop <-chan struct{}) {
+	go func() {
+		<-stop
+		if err := n.conn.Close(); err != nil {
+			log.Error("close notify socket %s: %s", n.path, err)
+		}
+	}()
+	buf := make([]byte, maxNotifyMessageBytes)
+	for {
+		size, _, err := n.conn.ReadFromUnix(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			log.Error("read notify socket %s: %s", n.path, err)
+			continue
+		}
+		n.handle(parseNotifyMessage(string(buf[:size])))
+	}
+	if err := os.Remove(n.path); err != nil && !os.IsNotExist(err) {
+		log.Error("remove notify socket %s: %s", n.path, err)
+	}
+}
+
+// parseNotifyMessage parses the newline separated KEY=VALUE assignments, lines without '=' are ignored.
+func parseNotifyMessage(msg string) map[string]string {
+	kvs := make(map[string]string)
+	for _, line := range strings.Split(msg, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			continue
+		}
+		kvs[kv[0]] = kv[1]
+	}
+	return kvs
+}
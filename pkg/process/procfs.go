@@ -0,0 +1,205 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// procfs doc: https://man7.org/linux/man-pages/man5/procfs.5.html
+
+// clockTicks is the USER_HZ of /proc/<pid>/stat, which is 100 on almost all linux platforms.
+const clockTicks = 100
+
+type procStat struct {
+	state string
+	ppid  int
+	pgrp  int
+	// session is the session id, the pid of the session leader.
+	session int
+	// startTime in clock ticks after boot, telling apart the processes reusing a pid.
+	startTime int64
+	cpuTime   time.Duration
+	nice      int
+	threads   int
+	rss       int64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	statPath := fmt.Sprintf("/proc/%d/stat", pid)
+	statBytes, err := os.ReadFile(statPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", statPath, err)
+	}
+	// the 2nd field comm is enclosed by parentheses and may contain spaces.
+	i := bytes.LastIndexByte(statBytes, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", statPath, statBytes)
+	}
+	// fields[0] is the 3rd field state.
+	fields := strings.Fields(string(statBytes[i+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("want at least 24 proc stat fields, got %d", len(fields)+2)
+	}
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+	return &procStat{
+		state:     fields[0],
+		ppid:      int(field(4)),
+		pgrp:      int(field(5)),
+		session:   int(field(6)),
+		startTime: field(22),
+		cpuTime:   time.Duration(field(14)+field(15)) * time.Second / clockTicks,
+		nice:      int(field(19)),
+		threads:   int(field(20)),
+		rss:       field(24) * int64(os.Getpagesize()),
+	}, nil
+}
+
+func readProcCmdline(pid int) (string, error) {
+	cmdlinePath := fmt.Sprintf("/proc/%d/cmdline", pid)
+	cmdline, err := os.ReadFile(cmdlinePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", cmdlinePath, err)
+	}
+	return string(bytes.TrimSpace(bytes.ReplaceAll(cmdline, []byte{0}, []byte(" ")))), nil
+}
+
+func countProcFds(pid int) (int, error) {
+	fdPath := fmt.Sprintf("/proc/%d/fd", pid)
+	fds, err := os.ReadDir(fdPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dir %s: %s", fdPath, err)
+	}
+	return len(fds), nil
+}
+
+// scanProcs returns the stat of all processes.
+func scanProcs() (map[int]*procStat, error) {
+	fis, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dir /proc: %s", err)
+	}
+	procs := make(map[int]*procStat, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		pid, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		// the process may have exited and reaped.
+		if stat, err := readProcStat(pid); err == nil {
+			procs[pid] = stat
+		}
+	}
+	return procs, nil
+}
+
+// readProcLimits returns the soft and hard limits like "1024:4096" of the given rlimits in /proc/<pid>/limits,
+// keyed by the names like "nofile" in config.
+func readProcLimits(pid int, names []string) (map[string]string, error) {
+	limitsPath := fmt.Sprintf("/proc/%d/limits", pid)
+	content, err := os.ReadFile(limitsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", limitsPath, err)
+	}
+	limits := make(map[string]string, len(names))
+	for _, line := range strings.Split(string(content), "\n") {
+		// Max open files            1024                 4096                 files
+		for _, name := range names {
+			title, ok := procLimitTitles[name]
+			if !ok || !strings.HasPrefix(line, title) {
+				continue
+			}
+			fields := strings.Fields(line[len(title):])
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid line in %s: %q", limitsPath, line)
+			}
+			limits[name] = fields[0] + ":" + fields[1]
+		}
+	}
+	return limits, nil
+}
+
+// readProcNSpid returns the pid of the process in its innermost pid namespace, from /proc/<pid>/status.
+func readProcNSpid(pid int) (int, error) {
+	statusPath := fmt.Sprintf("/proc/%d/status", pid)
+	content, err := os.ReadFile(statusPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %s", statusPath, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		// NSpid:	12345	1
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "NSpid:" {
+			return strconv.Atoi(fields[len(fields)-1])
+		}
+	}
+	return 0, fmt.Errorf("NSpid not found in %s", statusPath)
+}
+
+// rlimitNames are the rlimits configurable, in the order shown.
+var rlimitNames = []string{"nofile", "core", "nproc", "as"}
+
+// procLimitTitles are the titles in /proc/<pid>/limits of the rlimits.
+var procLimitTitles = map[string]string{
+	"nofile": "Max open files",
+	"core":   "Max core file size",
+	"nproc":  "Max processes",
+	"as":     "Max address space",
+}
+
+// readProcUmask returns the umask like "0022" in /proc/<pid>/status, which is given since linux 4.7.
+func readProcUmask(pid int) (string, error) {
+	statusPath := fmt.Sprintf("/proc/%d/status", pid)
+	content, err := os.ReadFile(statusPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %s", statusPath, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "Umask:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Umask:")), nil
+		}
+	}
+	return "", nil
+}
+
+func readProcOOMScoreAdj(pid int) (int, error) {
+	adjPath := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	content, err := os.ReadFile(adjPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %s", adjPath, err)
+	}
+	adj, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", adjPath, content)
+	}
+	return adj, nil
+}
+
+// ioClassNames are the names of the IO scheduling classes by value, class 0 means none set.
+var ioClassNames = []string{"none", "realtime", "best-effort", "idle"}
+
+// getIOPriority returns the IO scheduling class and priority of the process like "best-effort/4".
+func getIOPriority(pid int) (string, error) {
+	// IOPRIO_WHO_PROCESS
+	ioprio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, 1, uintptr(pid), 0)
+	if errno != 0 {
+		return "", fmt.Errorf("failed to get ioprio of process %d: %s", pid, errno)
+	}
+	class, priority := int(ioprio>>13), int(ioprio&0xff)
+	if class >= len(ioClassNames) {
+		return strconv.Itoa(int(ioprio)), nil
+	}
+	if class == 0 {
+		return ioClassNames[class], nil
+	}
+	return ioClassNames[class] + "/" + strconv.Itoa(priority), nil
+}
@@ -0,0 +1,123 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/health"
+)
+
+// readinessPollInterval is how often a starting program is checked whether it is ready or exited.
+const readinessPollInterval = 100 * time.Millisecond
+
+// maxMatchLineBytes is the maximum bytes of an unfinished line kept by lineMatcher.
+const maxMatchLineBytes = 64 * 1024
+
+// waitReady waits until the program started at startedAt with programConfig is ready according to the readiness config,
+// or it exited, or startSeconds passed. The caller must NOT hold c.mu, so the program could be stopped meanwhile.
+// matched fires for 'log' readiness, and prober is used for 'probe' readiness.
+func (c *Controller) waitReady(programConfig *config.Program, startedAt time.Time, matched <-chan struct{}, prober health.Prober) error {
+	var (
+		rc           = &programConfig.Readiness
+		startSeconds = programConfig.Process.StartSeconds
+		timeout      <-chan time.Time
+	)
+	if startSeconds > 0 || rc.Type == config.ReadinessNone {
+		timer := time.NewTimer(time.Duration(startSeconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-timeout:
+			if rc.Type != config.ReadinessNone {
+				return fmt.Errorf("program not ready in %d seconds", startSeconds)
+			}
+			if !c.lockedRunning() {
+				return fmt.Errorf("program not running after %d seconds", startSeconds)
+			}
+			return nil
+		case <-matched:
+			return nil
+		case <-ticker.C:
+		}
+		if !c.lockedRunning() {
+			return errors.New("program exited before ready")
+		}
+		switch rc.Type {
+		case config.ReadinessFile:
+			if fi, err := os.Stat(rc.File); err == nil && !fi.ModTime().Before(startedAt) {
+				return nil
+			}
+		case config.ReadinessProbe:
+			timeout := time.Duration(programConfig.HealthCheck.TimeoutSeconds) * time.Second
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, err := prober.Probe(ctx)
+			cancel()
+			if err == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// lineMatcher closes matched once a line written to it matches re, including the unfinished last line.
+type lineMatcher struct {
+	re      *regexp.Regexp
+	line    []byte
+	done    bool
+	matched chan struct{}
+	// once closes matched, shared by the matchers forked.
+	once *sync.Once
+}
+
+func newLineMatcher(re *regexp.Regexp) *lineMatcher {
+	return &lineMatcher{
+		re:      re,
+		matched: make(chan struct{}),
+		once:    &sync.Once{},
+	}
+}
+
+// fork returns a matcher of another stream, closing the same matched. nil is forked to nil.
+func (m *lineMatcher) fork() *lineMatcher {
+	if m == nil {
+		return nil
+	}
+	return &lineMatcher{re: m.re, matched: m.matched, once: m.once}
+}
+
+func (m *lineMatcher) Write(p []byte) (int, error) {
+	n := len(p)
+	for !m.done && len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m.line = append(m.line, p...)
+			p = nil
+		} else {
+			m.line = append(m.line, p[:i]...)
+			p = p[i+1:]
+		}
+		if m.re.Match(m.line) {
+			m.done = true
+			m.line = nil
+			m.once.Do(func() { close(m.matched) })
+			break
+		}
+		if i >= 0 {
+			m.line = m.line[:0]
+		} else if len(m.line) > maxMatchLineBytes {
+			m.line = append(m.line[:0], m.line[len(m.line)-maxMatchLineBytes:]...)
+		}
+	}
+	return n, nil
+}
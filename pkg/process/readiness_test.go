@@ -0,0 +1,81 @@
+package process
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestNotifyHelperProcess is not a test, but the notify program run by TestControllerNotifyMainPid.
+// It forks the main process, and notifies its MAINPID and then READY=1, as a daemon does by sd_notify.
+func TestNotifyHelperProcess(t *testing.T) {
+	if os.Getenv("SUP_TEST_NOTIFY_HELPER") != "1" {
+		return
+	}
+	main := exec.Command("sleep", "30")
+	if err := main.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	conn, err := net.Dial("unixgram", os.Getenv("NOTIFY_SOCKET"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if _, err := fmt.Fprintf(conn, "MAINPID=%d\nSTATUS=starting", main.Process.Pid); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// sup polls whether the program is running meanwhile.
+	time.Sleep(3 * readinessPollInterval)
+	if _, err := fmt.Fprint(conn, "READY=1\nSTATUS=ready"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	_ = main.Wait()
+	os.Exit(0)
+}
+
+// TestControllerNotifyMainPid checks the program notifying MAINPID before READY=1 is started with the main process.
+func TestControllerNotifyMainPid(t *testing.T) {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	c := runController(t, dir, fmt.Sprintf(`
+[programs.n.process]
+path = %q
+args = ["-test.run=^TestNotifyHelperProcess$"]
+startSeconds = 5
+[programs.n.process.envs]
+SUP_TEST_NOTIFY_HELPER = "1"
+[programs.n.readiness]
+type = "notify"
+[programs.n.log]
+path = "%%[1]s/n.log"
+`, executable))
+
+	if err := c.startHandler(); err != nil {
+		content, _ := os.ReadFile(filepath.Join(dir, "n.log"))
+		t.Fatalf("start: %s, output %q", err, content)
+	}
+	st := status(t, c)
+	c.mu.Lock()
+	launcher := c.cmd.Process.Pid
+	c.mu.Unlock()
+	if st.State != StateRunning || st.Pid == launcher || st.NotifyStatus != "ready" {
+		t.Errorf("got state %s pid %d status %q, want %s the main process forked by %d and status ready",
+			st.State, st.Pid, st.NotifyStatus, StateRunning, launcher)
+	}
+	if err := c.Stop(nil, &Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if isPidRunning(st.Pid) {
+		t.Errorf("main process %d running after stopped", st.Pid)
+	}
+}
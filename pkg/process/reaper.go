@@ -0,0 +1,200 @@
+package process
+
+import (
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/log"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER in linux/prctl.h.
+const prSetChildSubreaper = 36
+
+// reapInterval is how often the orphans are looked for besides on SIGCHLD.
+const reapInterval = time.Second
+
+// setSubreaper makes the orphaned descendants of the programs adopted by sup instead of init,
+// so they could still be tracked and reaped.
+func setSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// descendants tracks the processes of a program besides its main processes, so they are still known to belong to it
+// after orphaned and adopted by sup. A process is of the program if its parent is, or it is in a session or process
+// group led by a process of the program, even a gone one. The process started by sup leads its own session, so all
+// its descendants are tracked from the session until any calls setsid, after which it is tracked from its parent.
+type descendants struct {
+	mu sync.Mutex
+	// launcher is the process started by sup, reaped by whom started it rather than the reaper.
+	launcher int
+	// roots are the main processes, mapped to their start times against pid reuse.
+	roots map[int]int64
+	// pids maps a tracked process to its start time.
+	pids map[int]int64
+	// gone are the roots and tracked processes exited, whose sessions or process groups still have processes.
+	// The pid of a session or process group is not reused until all its processes exited.
+	gone map[int]bool
+}
+
+// reset forgets all tracked processes, and tracks the descendants of launcher from now on.
+func (d *descendants) reset(launcher int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.launcher = launcher
+	d.roots = make(map[int]int64)
+	d.pids = make(map[int]int64)
+	d.gone = make(map[int]bool)
+	if stat, err := readProcStat(launcher); err == nil {
+		d.roots[launcher] = stat.startTime
+	}
+}
+
+// addRoot tracks the descendants of the main process started at startTime too.
+func (d *descendants) addRoot(pid int, startTime int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pids, pid)
+	d.roots[pid] = startTime
+}
+
+// has reports whether the process is tracked, the main processes other than the launcher included.
+func (d *descendants) has(pid int, stat *procStat) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	startTime, ok := d.pids[pid]
+	if !ok && pid != d.launcher {
+		startTime, ok = d.roots[pid]
+	}
+	return ok && startTime == stat.startTime
+}
+
+// update tracks the new descendants found in procs and forgets the gone ones,
+// and returns the alive tracked processes, the main processes excluded.
+func (d *descendants) update(procs map[int]*procStat) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.roots) == 0 {
+		return nil
+	}
+	for _, m := range []map[int]int64{d.roots, d.pids} {
+		for pid, startTime := range m {
+			if stat, ok := procs[pid]; !ok || stat.startTime != startTime {
+				delete(m, pid)
+				d.gone[pid] = true
+			}
+		}
+	}
+	used := make(map[int]bool)
+	for _, stat := range procs {
+		used[stat.session] = true
+		used[stat.pgrp] = true
+	}
+	for pid := range d.gone {
+		// a process with the pid is another one reusing it.
+		if _, reused := procs[pid]; reused || !used[pid] {
+			delete(d.gone, pid)
+		}
+	}
+
+	known := func(pid int) bool {
+		_, isRoot := d.roots[pid]
+		_, tracked := d.pids[pid]
+		return isRoot || tracked || d.gone[pid]
+	}
+	supPid := os.Getpid()
+	for found := true; found; {
+		found = false
+		for pid, stat := range procs {
+			if pid <= 1 || pid == supPid || known(pid) {
+				continue
+			}
+			if known(stat.ppid) || known(stat.session) || known(stat.pgrp) {
+				d.pids[pid] = stat.startTime
+				found = true
+			}
+		}
+	}
+
+	var alive []int
+	for pid := range d.pids {
+		if procs[pid].state != "Z" {
+			alive = append(alive, pid)
+		}
+	}
+	sort.Ints(alive)
+	return alive
+}
+
+// reapOrphans reaps the orphans adopted by sup once they exited, and keeps tracking the descendants of programs.
+func reapOrphans(stop <-chan struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGCHLD)
+	defer signal.Stop(sigCh)
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sigCh:
+		case <-ticker.C:
+		}
+		reap()
+	}
+}
+
+func reap() {
+	procs, err := scanProcs()
+	if err != nil {
+		log.Error("reap orphans: %s", err)
+		return
+	}
+	supPid := os.Getpid()
+	for pid, stat := range procs {
+		if stat.ppid != supPid || stat.state != "Z" {
+			continue
+		}
+		owner := orphanOwner(pid, stat)
+		// processes spawned by sup lead their own process groups, and are reaped by whom started them.
+		if len(owner) == 0 && stat.pgrp == pid {
+			continue
+		}
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err != nil || wpid != pid {
+			continue
+		}
+		if len(owner) == 0 {
+			owner = "unknown"
+		}
+		log.Info("reaped orphan process %d of program %s, %s", pid, owner, describeWaitStatus(ws))
+	}
+	for _, name := range dispatcher.names {
+		dispatcher.controllers[name].descendants.update(procs)
+	}
+}
+
+// orphanOwner returns the name of the program the orphan belongs to, or empty if not known.
+func orphanOwner(pid int, stat *procStat) string {
+	for _, name := range dispatcher.names {
+		if dispatcher.controllers[name].descendants.has(pid, stat) {
+			return name
+		}
+	}
+	return ""
+}
+
+func describeWaitStatus(ws syscall.WaitStatus) string {
+	if ws.Signaled() {
+		return "killed by " + config.SignalName(ws.Signal())
+	}
+	return "exited with code " + strconv.Itoa(ws.ExitStatus())
+}
@@ -0,0 +1,215 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/run"
+)
+
+// startScript starts the shell script leading its own session as programs do, with env DIR of a temp dir returned.
+func startScript(t *testing.T, script string) (*exec.Cmd, string) {
+	t.Helper()
+	if err := setSubreaper(); err != nil {
+		t.Fatalf("set child subreaper: %s", err)
+	}
+	dir := t.TempDir()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = []string{"DIR=" + dir, "PATH=" + os.Getenv("PATH")}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd, dir
+}
+
+// readPid waits the pid written to the file by the script, and kills the process at the end of the test.
+func readPid(t *testing.T, filename string) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, err := os.ReadFile(filename)
+		if err == nil && strings.HasSuffix(string(content), "\n") {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { killAndReap(pid) })
+			return pid
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no pid written to %s in 5s", filename)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// killAndReap kills the process adopted by the test as a child subreaper, and reaps it.
+func killAndReap(pid int) {
+	_ = syscall.Kill(pid, syscall.SIGKILL)
+	var ws syscall.WaitStatus
+	_, _ = syscall.Wait4(pid, &ws, 0, nil)
+}
+
+func updateDescendants(t *testing.T, d *descendants) []int {
+	t.Helper()
+	procs, err := scanProcs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.update(procs)
+}
+
+// TestDescendantsDoubleFork checks a worker double forked is tracked, even if orphaned before the first scan.
+func TestDescendantsDoubleFork(t *testing.T) {
+	cmd, dir := startScript(t, `(sleep 30 & echo $! > "$DIR/worker"); exit 0`)
+	var d descendants
+	d.reset(cmd.Process.Pid)
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	worker := readPid(t, filepath.Join(dir, "worker"))
+	stat, err := readProcStat(worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.ppid != os.Getpid() {
+		t.Fatalf("got parent %d of worker %d, want adopted by %d", stat.ppid, worker, os.Getpid())
+	}
+
+	if got := updateDescendants(t, &d); fmt.Sprint(got) != fmt.Sprint([]int{worker}) {
+		t.Errorf("got descendants %v, want %v", got, []int{worker})
+	}
+	if !d.has(worker, stat) {
+		t.Errorf("worker %d not tracked", worker)
+	}
+	killAndReap(worker)
+	if got := updateDescendants(t, &d); len(got) > 0 {
+		t.Errorf("got descendants %v after worker exited, want none", got)
+	}
+}
+
+// TestDescendantsSetsid checks a worker double forked by a daemon, which left the session of the program by setsid,
+// is tracked by the session of the daemon, even if the daemon exited before the worker scanned.
+func TestDescendantsSetsid(t *testing.T) {
+	cmd, dir := startScript(t, `setsid /bin/sh -c '
+until [ -e "$DIR/fork" ]; do sleep 0.05; done
+(sleep 30 & echo $! > "$DIR/worker")' &
+echo $! > "$DIR/daemon"
+until [ -e "$DIR/exit" ]; do sleep 0.05; done`)
+	var d descendants
+	d.reset(cmd.Process.Pid)
+	daemon := readPid(t, filepath.Join(dir, "daemon"))
+	if got := updateDescendants(t, &d); !containsInt(got, daemon) {
+		t.Fatalf("got descendants %v, want daemon %d in", got, daemon)
+	}
+
+	touch(t, filepath.Join(dir, "exit"))
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(dir, "fork"))
+	worker := readPid(t, filepath.Join(dir, "worker"))
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(daemon, &ws, 0, nil); err != nil {
+		t.Fatalf("wait daemon %d adopted: %s", daemon, err)
+	}
+	stat, err := readProcStat(worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.ppid != os.Getpid() || stat.session != daemon {
+		t.Fatalf("got parent %d session %d of worker %d, want %d and %d", stat.ppid, stat.session, worker, os.Getpid(), daemon)
+	}
+	if got := updateDescendants(t, &d); !containsInt(got, worker) {
+		t.Errorf("got descendants %v, want worker %d in", got, worker)
+	}
+}
+
+func touch(t *testing.T, filename string) {
+	t.Helper()
+	if err := os.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestControllerKeepsDescendants checks the program keeps running while the worker double forked by it is alive.
+func TestControllerKeepsDescendants(t *testing.T) {
+	dir := t.TempDir()
+	c := runController(t, dir, `
+[programs.d.process]
+path = "/bin/sh"
+args = ["-c", "(sleep 30 & echo $! > %[1]s/worker); exit 0"]
+startSeconds = 1
+[programs.d.log]
+path = "%[1]s/d.log"
+`)
+
+	if err := c.startHandler(); err != nil {
+		t.Fatal(err)
+	}
+	worker := readPid(t, filepath.Join(dir, "worker"))
+	// the launcher has exited, and its exit been handled.
+	time.Sleep(time.Second)
+	st := status(t, c)
+	if st.State != StateRunning || st.Pid != worker {
+		t.Fatalf("got state %s pid %d after the launcher exited, want %s pid %d", st.State, st.Pid, StateRunning, worker)
+	}
+
+	if err := c.Stop(nil, &Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if isPidRunning(worker) {
+		t.Errorf("worker %d running after stopped", worker)
+	}
+	if st := status(t, c); st.State != StateStopped {
+		t.Errorf("got state %s after stopped, want %s", st.State, StateStopped)
+	}
+}
+
+func status(t *testing.T, c *Controller) ProgramStatus {
+	t.Helper()
+	rsp := &Response{}
+	if err := c.Status(nil, rsp); err != nil {
+		t.Fatal(err)
+	}
+	return rsp.Statuses[0]
+}
+
+// runController runs the controller of the only program in the config, in which %[1]s is replaced with dir,
+// until the end of the test.
+func runController(t *testing.T, dir, programConfig string) *Controller {
+	t.Helper()
+	if err := setSubreaper(); err != nil {
+		t.Fatalf("set child subreaper: %s", err)
+	}
+	configPath := filepath.Join(dir, "sup.toml")
+	content := fmt.Sprintf("[sup]\nsocket = \"%[1]s/sup.sock\"\n"+programConfig, dir)
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := cfg.ProgramNames()
+	if len(names) != 1 {
+		t.Fatalf("got programs %v, want one", names)
+	}
+	c := newController(cfg.Programs[names[0]])
+	runs := []run.Func{c.run}
+	if c.notifier != nil {
+		runs = append(runs, c.notifier.run)
+	}
+	rw := run.Run(runs...)
+	t.Cleanup(rw.StopAndWait)
+	return c
+}
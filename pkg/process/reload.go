@@ -0,0 +1,143 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"github.com/sequix/sup/pkg/config"
+	"github.com/sequix/sup/pkg/log"
+)
+
+// pendingConfig is the config reloaded, applied at the next start of the program.
+type pendingConfig struct {
+	config   *config.Program
+	template *exec.Cmd
+	baseEnv  map[string]string
+}
+
+// ReloadConfig re-reads the config file and reports the changes of every program, which are applied at its next start.
+// The programs added or removed, and the socket of sup, are not changed until sup restarted.
+func (d *Dispatcher) ReloadConfig(_ *Request, rsp *Response) error {
+	report, failed, err := d.reloadConfig()
+	if err != nil {
+		return err
+	}
+	if failed {
+		// the response is not sent with an error.
+		return errors.New(strings.TrimSuffix(report, "\n"))
+	}
+	rsp.Message = report
+	return nil
+}
+
+// reloadConfig returns the report of the changes, and whether any program failed to reload.
+func (d *Dispatcher) reloadConfig() (string, bool, error) {
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		return "", false, err
+	}
+	var (
+		b      strings.Builder
+		failed bool
+	)
+	if socket := config.G.SupConfig.Socket; cfg.SupConfig.Socket != socket {
+		fmt.Fprintf(&b, "sup.socket: %s -> %s, ignored until sup restarted\n", socket, cfg.SupConfig.Socket)
+	}
+	for _, name := range cfg.ProgramNames() {
+		if _, ok := d.controllers[name]; !ok {
+			fmt.Fprintf(&b, "program %s: added, ignored until sup restarted\n", name)
+		}
+	}
+	for _, name := range d.names {
+		programConfig, ok := cfg.Programs[name]
+		if !ok {
+			fmt.Fprintf(&b, "program %s: removed, ignored until sup restarted\n", name)
+			continue
+		}
+		c := d.controllers[name]
+		changes, running, err := c.reloadConfig(programConfig)
+		switch {
+		case err != nil:
+			failed = true
+			fmt.Fprintf(&b, "program %s: not reloaded, %s\n", name, err)
+		case len(changes) == 0:
+			fmt.Fprintf(&b, "program %s: not changed\n", name)
+		case running:
+			fmt.Fprintf(&b, "program %s: changed, applied at the next start, restart it to apply now\n", name)
+		default:
+			fmt.Fprintf(&b, "program %s: changed, applied at the next start\n", name)
+		}
+		for _, change := range changes {
+			fmt.Fprintf(&b, "  %s\n", change)
+		}
+	}
+	return b.String(), failed, nil
+}
+
+// ReloadConfigOnSignal reloads the config as the reload-config action does, logging the report.
+func ReloadConfigOnSignal() {
+	report, failed, err := dispatcher.reloadConfig()
+	if err != nil {
+		log.Error("reload config: %s", err)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(report, "\n"), "\n") {
+		log.Info("reload config: %s", line)
+	}
+	if failed {
+		log.Error("reload config: some programs not reloaded")
+	}
+}
+
+// reloadConfig prepares the program config to be applied at the next start, and returns the changes from the config
+// of the program running or last started, and whether the program is running.
+func (c *Controller) reloadConfig(programConfig *config.Program) ([]string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changes := config.Diff(c.config, programConfig)
+	if len(changes) == 0 {
+		c.pending = nil
+		return nil, c.running(), nil
+	}
+	if !reflect.DeepEqual(c.config.Notify, programConfig.Notify) {
+		return changes, false, errors.New("notify changed, which requires restarting sup")
+	}
+	template, baseEnv, err := newTemplate(programConfig)
+	if err != nil {
+		return changes, false, err
+	}
+	if err := enableCgroupControllers(programConfig); err != nil {
+		return changes, false, err
+	}
+	c.pending = &pendingConfig{config: programConfig, template: template, baseEnv: baseEnv}
+	log.Info("reloaded config of program %s, %d changes applied at the next start", c.name, len(changes))
+	return changes, c.running(), nil
+}
+
+// applyPending applies the pending config before the program starts. The caller must hold c.mu.
+// The log files are opened here rather than reloaded, so that they are not rotated by two loggers at once.
+func (c *Controller) applyPending() error {
+	p := c.pending
+	if p == nil {
+		return nil
+	}
+	if !reflect.DeepEqual(c.config.Log, p.config.Log) {
+		loggers, err := newLoggers(p.config)
+		if err != nil {
+			return err
+		}
+		// the output of the last start may still be being copied to the old log files.
+		c.loggers.closeDrained(c.name)
+		c.loggers = loggers
+	}
+	c.pending = nil
+	c.config = p.config
+	c.template = p.template
+	c.baseEnv = p.baseEnv
+	c.backoff.config = &p.config.Process.Backoff
+	log.Info("applied reloaded config of program %s", c.name)
+	return nil
+}
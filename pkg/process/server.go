@@ -11,93 +11,34 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 
+	"github.com/sequix/sup/pkg/cgroup"
 	"github.com/sequix/sup/pkg/config"
 	"github.com/sequix/sup/pkg/log"
-	"github.com/sequix/sup/pkg/rotate"
 	"github.com/sequix/sup/pkg/run"
 )
 
 var (
-	processConfig *config.Process
-	server        *rpc.Server
-	controller    *Controller
-	unixListener  *net.UnixListener
+	server       *rpc.Server
+	dispatcher   *Dispatcher
+	unixListener *net.UnixListener
 )
 
 func InitServer() {
-	processConfig = &config.G.ProgramConfig.Process
-	logConfig := &config.G.ProgramConfig.Log
-
-	cmd := exec.Command(processConfig.Path, processConfig.Args...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{}
-
-	if processConfig.User != "" {
-		uid, err := getUid(processConfig.User)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		if cmd.SysProcAttr.Credential == nil {
-			cmd.SysProcAttr.Credential = &syscall.Credential{}
-		}
-		cmd.SysProcAttr.Credential.Uid = uid
-	}
-
-	if processConfig.Group != "" {
-		gid, err := getGid(processConfig.Group)
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		if cmd.SysProcAttr.Credential == nil {
-			cmd.SysProcAttr.Credential = &syscall.Credential{}
-		}
-		cmd.SysProcAttr.Credential.Gid = gid
+	if err := setSubreaper(); err != nil {
+		log.Fatal("set sup as child subreaper: %s", err)
 	}
 
-	supEnvs := os.Environ()
-	envsMap := make(map[string]string, len(supEnvs)+len(processConfig.Envs))
-	for _, supEnv := range supEnvs {
-		eqi := strings.Index(supEnv, "=")
-		if eqi == -1 {
-			log.Fatal("invalid env %s", supEnv)
-		}
-		kv := strings.SplitN(supEnv, "=", 2)
-		envsMap[kv[0]] = kv[1]
-	}
-	for k, v := range processConfig.Envs {
-		envsMap[k] = v
+	dispatcher = &Dispatcher{
+		controllers: make(map[string]*Controller, len(config.G.Programs)),
+		names:       config.G.ProgramNames(),
 	}
-	envs := make([]string, 0, len(envsMap))
-	for k, v := range envsMap {
-		envs = append(envs, k+"="+v)
-	}
-	cmd.Env = envs
-	cmd.Dir = processConfig.WorkDir
-
-	logger, err := rotate.NewFileWriter(
-		rotate.WithFilename(logConfig.Path),
-		rotate.WithMaxBytes(int64(logConfig.MaxSize)*1024*1024),
-		rotate.WithMaxBackups(logConfig.MaxBackups),
-		rotate.WithCompress(logConfig.Compress),
-		rotate.WithMergeCompressedBackups(logConfig.MergeCompressed),
-		rotate.WithMaxAge(time.Hour*24*time.Duration(logConfig.MaxDays)),
-	)
-	if err != nil {
-		log.Fatal("init rotate logger: %s", err)
-	}
-
-	controller = &Controller{
-		cmd:       cmd,
-		logger:    logger,
-		startedCh: make(chan struct{}),
-		exitedCh:  make(chan struct{}),
-		wantStop:  0,
-		wantExit:  0,
+	for _, name := range dispatcher.names {
+		dispatcher.controllers[name] = newController(config.G.Programs[name])
 	}
 
 	server = rpc.NewServer()
-	if err := server.Register(controller); err != nil {
+	if err := server.RegisterName("Controller", dispatcher); err != nil {
 		log.Fatal("registry controller to rpc: %s", err)
 	}
 
@@ -119,10 +60,126 @@ func InitServer() {
 	if err != nil {
 		log.Fatal("listen to socket %q: %s", socketPath, err)
 	}
+}
+
+func newController(programConfig *config.Program) *Controller {
+	template, baseEnv, err := newTemplate(programConfig)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	loggers, err := newLoggers(programConfig)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	c := &Controller{
+		name:        programConfig.Name,
+		config:      programConfig,
+		cmd:         template,
+		template:    template,
+		baseEnv:     baseEnv,
+		loggers:     loggers,
+		startedCh:   make(chan *exec.Cmd),
+		exitedCh:    make(chan *exec.Cmd),
+		unhealthyCh: make(chan *exec.Cmd),
+		wantStop:    0,
+		wantExit:    0,
+		state:       StateNotStarted,
+		backoff:     backoff{config: &programConfig.Process.Backoff},
+	}
+
+	if err := enableCgroupControllers(programConfig); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if programConfig.Notify.Enabled {
+		uid, gid := -1, -1
+		if cred := template.SysProcAttr.Credential; cred != nil {
+			if programConfig.Process.User != "" {
+				uid = int(cred.Uid)
+			}
+			if programConfig.Process.User != "" || programConfig.Process.Group != "" {
+				gid = int(cred.Gid)
+			}
+		}
+		if c.notifier, err = newNotifier(programConfig.Notify.Socket, uid, gid, c.notified); err != nil {
+			log.Fatal("init notify socket of program %s: %s", programConfig.Name, err)
+		}
+	}
+	return c
+}
+
+// newTemplate returns the cmd each start of the program is created from, and the env inherited from sup and of the user.
+func newTemplate(programConfig *config.Program) (*exec.Cmd, map[string]string, error) {
+	processConfig := &programConfig.Process
+
+	cmd := exec.Command(processConfig.Path, processConfig.Args...)
+	// the program leads its own session and process group, so that all its descendants could be tracked and signaled
+	// together.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	var (
+		u   *user.User
+		err error
+	)
+	if processConfig.User != "" {
+		if u, err = user.Lookup(processConfig.User); err != nil {
+			return nil, nil, fmt.Errorf("failed to lookup user %q of program %s: %s", processConfig.User, programConfig.Name, err)
+		}
+	}
+	if u != nil || processConfig.Group != "" || processConfig.Groups != nil {
+		if cmd.SysProcAttr.Credential, err = newCredential(u, processConfig.Group, processConfig.Groups); err != nil {
+			return nil, nil, fmt.Errorf("credential of program %s: %s", programConfig.Name, err)
+		}
+	}
+
+	// the ambient capabilities are raised after the credential set.
+	cmd.SysProcAttr.AmbientCaps = processConfig.Capabilities.AmbientCaps
+	cmd.SysProcAttr.Cloneflags = processConfig.Cloneflags
+	cmd.SysProcAttr.Chroot = processConfig.Chroot
+
+	baseEnv := make(map[string]string)
+	if processConfig.InheritEnv {
+		for _, supEnv := range os.Environ() {
+			kv := strings.SplitN(supEnv, "=", 2)
+			if len(kv) != 2 {
+				return nil, nil, fmt.Errorf("invalid env %s", supEnv)
+			}
+			if len(processConfig.InheritEnvAllow) > 0 && !config.MatchEnvName(processConfig.InheritEnvAllow, kv[0]) ||
+				config.MatchEnvName(processConfig.InheritEnvDeny, kv[0]) {
+				continue
+			}
+			baseEnv[kv[0]] = kv[1]
+		}
+	}
+	if u != nil {
+		// the identity of the user, unless given by envFiles or envs.
+		baseEnv["HOME"] = u.HomeDir
+		baseEnv["USER"] = u.Username
+		baseEnv["LOGNAME"] = u.Username
+		if shell, err := lookupShell(u.Username); err != nil {
+			log.Warn("lookup shell of user %s: %s", u.Username, err)
+		} else if len(shell) > 0 {
+			baseEnv["SHELL"] = shell
+		}
+	}
+	cmd.Dir = processConfig.WorkDir
+	return cmd, baseEnv, nil
+}
 
-	if processConfig.AutoStart {
-		go func() { _ = controller.startHandler() }()
+// enableCgroupControllers enables the controllers for the resources of the program in its cgroup parent.
+func enableCgroupControllers(programConfig *config.Program) error {
+	if !programConfig.Cgroup.Enabled {
+		return nil
+	}
+	parent, err := cgroup.New(programConfig.Cgroup.Parent)
+	if err != nil {
+		return fmt.Errorf("init cgroup of program %s: %s", programConfig.Name, err)
 	}
+	if err := parent.EnableControllers(programConfig.Resources.Controllers()...); err != nil {
+		return fmt.Errorf("enable cgroup controllers for program %s: %s", programConfig.Name, err)
+	}
+	return nil
 }
 
 func removeNotUsingSocket(path string) error {
@@ -163,7 +220,15 @@ func removeNotUsingSocket(path string) error {
 }
 
 func Serve(stop <-chan struct{}) {
-	controllerRw := run.Run(controller.run)
+	runs := []run.Func{reapOrphans}
+	for _, name := range dispatcher.names {
+		c := dispatcher.controllers[name]
+		runs = append(runs, c.run)
+		if c.notifier != nil {
+			runs = append(runs, c.notifier.run)
+		}
+	}
+	controllerRw := run.Run(runs...)
 
 	go func() {
 		<-stop
@@ -186,19 +251,58 @@ func Serve(stop <-chan struct{}) {
 	}
 }
 
-func getUid(username string) (uint32, error) {
-	u, err := user.Lookup(username)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get uid of user %q: %s", username, err)
+// newCredential returns the credential of user u, which is the current user of sup if nil.
+// The primary group is that of u unless group given, and the supplementary groups are those of u
+// in /etc/group unless groups given.
+func newCredential(u *user.User, group string, groups []string) (*syscall.Credential, error) {
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
 	}
-	uid, err := strconv.Atoi(u.Uid)
-	if err != nil {
-		return 0, fmt.Errorf("invalid uid %q: %s", u.Uid, err)
+	if u != nil {
+		uid, err := strconv.Atoi(u.Uid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid %q: %s", u.Uid, err)
+		}
+		cred.Uid = uint32(uid)
+		if cred.Gid, err = getGid(u.Gid); err != nil {
+			return nil, err
+		}
+	}
+	if group != "" {
+		gid, err := getGid(group)
+		if err != nil {
+			return nil, err
+		}
+		cred.Gid = gid
+	}
+	if groups == nil && u != nil {
+		var err error
+		if groups, err = u.GroupIds(); err != nil {
+			return nil, fmt.Errorf("failed to get groups of user %q: %s", u.Username, err)
+		}
 	}
-	return uint32(uid), nil
+	if groups == nil {
+		// keeps those of sup, as without a user or groups given.
+		cred.NoSetGroups = true
+		return cred, nil
+	}
+	cred.Groups = make([]uint32, 0, len(groups))
+	for _, g := range groups {
+		gid, err := getGid(g)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+	return cred, nil
 }
 
+// getGid returns the gid of the group given by name or gid.
 func getGid(group string) (uint32, error) {
+	if gid, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
 	g, err := user.LookupGroup(group)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get gid of group %q: %s", group, err)
@@ -209,3 +313,19 @@ func getGid(group string) (uint32, error) {
 	}
 	return uint32(gid), nil
 }
+
+// lookupShell returns the login shell of the user in /etc/passwd, empty if not given.
+func lookupShell(username string) (string, error) {
+	content, err := os.ReadFile("/etc/passwd")
+	if err != nil {
+		return "", fmt.Errorf("failed to read /etc/passwd: %s", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		// root:x:0:0:root:/root:/bin/bash
+		fields := strings.Split(line, ":")
+		if len(fields) == 7 && fields[0] == username {
+			return fields[6], nil
+		}
+	}
+	return "", fmt.Errorf("user %q not found in /etc/passwd", username)
+}
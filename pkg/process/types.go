@@ -1,11 +1,69 @@
 package process
 
+import (
+	"time"
+
+	"github.com/sequix/sup/pkg/cgroup"
+	"github.com/sequix/sup/pkg/health"
+)
+
 type Request struct {
+	// Program is the name of the requested program, empty or "all" for every program.
+	Program string
 }
 
 type Response struct {
 	Message string
 	SupPid  int
+	// Forced are the names of programs killed since they did not exit in stopTimeout.
+	Forced []string
+	// Statuses of the requested programs, in name order.
+	Statuses []ProgramStatus
+}
+
+// ProgramStatus is the status of a supervised program, resource usages are of its main process.
+type ProgramStatus struct {
+	Name          string     `json:"name"`
+	State         State      `json:"state"`
+	Pid           int        `json:"pid,omitempty"`
+	ProcState     string     `json:"procState,omitempty"`
+	Command       string     `json:"command,omitempty"`
+	StartTime     *time.Time `json:"startTime,omitempty"`
+	UptimeSeconds int64      `json:"uptimeSeconds"`
+	RetryTime     *time.Time `json:"retryTime,omitempty"`
+	Restarts      int        `json:"restarts"`
+	// Exited is whether the program has exited since sup started, LastExitCode is valid if so unless LastSignal given.
+	// A pointer to the exit code would not do, since gob sends no pointer to zero.
+	Exited       bool   `json:"exited"`
+	LastExitCode int    `json:"lastExitCode"`
+	LastSignal   string `json:"lastSignal,omitempty"`
+	// Children are the other alive processes of the program, in its cgroup or process group.
+	Children   []int   `json:"children"`
+	RSSBytes   int64   `json:"rssBytes"`
+	CPUSeconds float64 `json:"cpuSeconds"`
+	FDs        int     `json:"fds"`
+	Threads    int     `json:"threads"`
+	// Nice, IOPriority like "best-effort/4", Umask like "0022", OOMScoreAdj and Rlimits like "1024:4096" keyed by
+	// names like "nofile" are the attributes of the main process.
+	Nice        int               `json:"nice"`
+	IOPriority  string            `json:"ioPriority,omitempty"`
+	Umask       string            `json:"umask,omitempty"`
+	OOMScoreAdj int               `json:"oomScoreAdj"`
+	Rlimits     map[string]string `json:"rlimits,omitempty"`
+	// AttrsConfigured is whether any of the attributes above is configured for the program running.
+	AttrsConfigured bool `json:"attrsConfigured"`
+	// Cgroup is the usage of the cgroup of the program, nil if cgroup disabled or the program not running.
+	Cgroup *cgroup.Stat `json:"cgroup,omitempty"`
+	// OOMKills is the number of processes of the program killed by OOM killer since sup started.
+	OOMKills int `json:"oomKills"`
+	// NotifyState is the last state like "ready", "reloading" and "stopping" notified by sd_notify.
+	NotifyState string `json:"notifyState,omitempty"`
+	// NotifyStatus is the last STATUS notified by sd_notify.
+	NotifyStatus string `json:"notifyStatus,omitempty"`
+	// Health is nil if health check disabled or the program not running.
+	Health *health.Status `json:"health,omitempty"`
+	// Error occurred when collecting the status.
+	Error string `json:"error,omitempty"`
 }
 
 const (
@@ -16,4 +74,29 @@ const (
 	ActionReload  = "reload"
 	ActionStatus  = "status"
 	ActionExit    = "exit"
+	// ActionReloadConfig re-reads the config file of sup, not to be confused with ActionReload of the program.
+	ActionReloadConfig = "reload-config"
+)
+
+// output formats of status action.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// State of a supervised program.
+type State string
+
+const (
+	StateNotStarted State = "NotStarted"
+	// StateStarting the program was started and Sup is waiting for it to be ready.
+	StateStarting State = "Starting"
+	StateRunning  State = "Running"
+	StateStopped  State = "Stopped"
+	// StateExited the program exited and would not be restarted automatically.
+	StateExited State = "Exited"
+	// StateBackoff the program exited and is waiting to be restarted automatically.
+	StateBackoff State = "Backoff"
+	// StateFatal the program was restarted too many times, and would not be restarted until started by CLI.
+	StateFatal State = "Fatal"
 )
@@ -1,6 +1,7 @@
 package rotate
 
 import (
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -47,12 +48,22 @@ type FileWriter struct {
 	// is to retain all old log files.
 	maxAge time.Duration
 
+	// schedule is when to rotate regardless of the size, even if nothing written.
+	// The default is to rotate by maxBytes only.
+	schedule *Schedule
+
 	// make align check happy
 	mu     sync.Mutex
 	backMu sync.Mutex
 	size   int64
 	file   *os.File
 	stop   *run.Runner
+	// backgrounds are the backups being compressed and cleaned.
+	backgrounds sync.WaitGroup
+	// inLine is true if the last line written is unfinished.
+	inLine bool
+	// rotateAtLineEnd is true if the scheduled rotation is waiting for the unfinished line.
+	rotateAtLineEnd bool
 }
 
 type Option func(*FileWriter)
@@ -93,6 +104,13 @@ func WithMaxAge(maxAge time.Duration) Option {
 	}
 }
 
+// WithSchedule rotates the log file on the schedule besides maxBytes, which could be 0 to rotate on the schedule only.
+func WithSchedule(schedule *Schedule) Option {
+	return func(w *FileWriter) {
+		w.schedule = schedule
+	}
+}
+
 func NewFileWriter(opts ...Option) (*FileWriter, error) {
 	fw := &FileWriter{
 		maxBytes: 128 * 1024 * 1024,
@@ -103,18 +121,30 @@ func NewFileWriter(opts ...Option) (*FileWriter, error) {
 	if len(fw.filename) == 0 {
 		return nil, fmt.Errorf("expected non-empty filename")
 	}
-	if fw.maxBytes <= 0 {
-		return nil, fmt.Errorf("expected maxBytes >= 0, got %d", fw.maxBytes)
+	if fw.maxBytes < 0 || fw.maxBytes == 0 && fw.schedule == nil {
+		return nil, fmt.Errorf("expected maxBytes > 0, got %d", fw.maxBytes)
 	}
+	var runs []run.Func
 	if fw.maxAge > 0 {
-		fw.stop = run.Run(fw.ager)
+		runs = append(runs, fw.ager)
+	}
+	if fw.schedule != nil {
+		runs = append(runs, fw.scheduler)
+	}
+	if len(runs) > 0 {
+		fw.stop = run.Run(runs...)
 	}
 	return fw, nil
 }
 
-// Write implements io.Writer.  If a write would cause the log file to be larger
+// maxLineBytes is the maximum bytes a line could make the log file larger than maxBytes,
+// longer lines are split by rotation.
+const maxLineBytes = 1024 * 1024
+
+// Write implements io.Writer.  If a line would cause the log file to be larger
 // than maxBytes, the file is closed, rotate to include a timestamp of the
-// current time.
+// current time before the line, so that a line is never split into two files,
+// unless it makes the file larger than maxBytes by more than maxLineBytes.
 func (w *FileWriter) Write(p []byte) (n int, err error) {
 	w.mu.Lock()
 	n, err = w.write(p)
@@ -123,6 +153,65 @@ func (w *FileWriter) Write(p []byte) (n int, err error) {
 }
 
 func (w *FileWriter) write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if w.inLine {
+			// completes the unfinished line first.
+			if i := bytes.IndexByte(p, '\n'); i >= 0 {
+				chunk = p[:i+1]
+			}
+			if limit := w.maxBytes + maxLineBytes - w.size; w.maxBytes > 0 && int64(len(chunk)) > limit {
+				if limit <= 0 {
+					if err = w.rotate(); err != nil {
+						return
+					}
+					continue
+				}
+				chunk = chunk[:limit]
+			}
+		} else if room := w.maxBytes - w.size; w.maxBytes > 0 && int64(len(p)) > room {
+			// writes the whole lines fitting in, or rotates before the line.
+			i := -1
+			if room > 0 {
+				i = bytes.LastIndexByte(p[:room], '\n')
+			}
+			switch {
+			case i >= 0:
+				chunk = p[:i+1]
+			case w.size > 0:
+				if err = w.rotate(); err != nil {
+					return
+				}
+				continue
+			default:
+				// a line longer than maxBytes is written to the empty file, split if longer than maxLineBytes more.
+				if j := bytes.IndexByte(p, '\n'); j >= 0 {
+					chunk = p[:j+1]
+				}
+				if limit := w.maxBytes + maxLineBytes; int64(len(chunk)) > limit {
+					chunk = chunk[:limit]
+				}
+			}
+		}
+
+		var m int
+		m, err = w.writeFile(chunk)
+		n += m
+		if err != nil {
+			return
+		}
+		p = p[len(chunk):]
+		w.inLine = chunk[len(chunk)-1] != '\n'
+		if w.rotateAtLineEnd && !w.inLine {
+			if err = w.rotate(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (w *FileWriter) writeFile(p []byte) (n int, err error) {
 	if w.file == nil {
 		if err = os.MkdirAll(filepath.Dir(w.filename), 0755); err != nil {
 			return
@@ -151,15 +240,17 @@ func (w *FileWriter) write(p []byte) (n int, err error) {
 	}
 
 	w.size += int64(n)
-	if w.maxBytes > 0 && w.size > w.maxBytes {
-		err = w.rotate()
-	}
-
 	return
 }
 
 // Close implements io.Closer, and closes the current logfile.
 func (w *FileWriter) Close() (err error) {
+	// stopped before locking, since the ager and scheduler lock too.
+	if w.stop != nil {
+		w.stop.StopAndWait()
+		w.stop = nil
+	}
+	w.backgrounds.Wait()
 	w.mu.Lock()
 	w.backMu.Lock()
 	if w.file != nil {
@@ -167,9 +258,6 @@ func (w *FileWriter) Close() (err error) {
 		w.file = nil
 		w.size = 0
 	}
-	if w.stop != nil {
-		w.stop.StopAndWait()
-	}
 	w.backMu.Unlock()
 	w.mu.Unlock()
 	return
@@ -190,6 +278,25 @@ func (w *FileWriter) ager(stop <-chan struct{}) {
 	}
 }
 
+// scheduler rotates the log file on the schedule.
+func (w *FileWriter) scheduler(stop <-chan struct{}) {
+	for {
+		now := timeNow()
+		timer := time.NewTimer(w.schedule.Next(now).Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			w.mu.Lock()
+			if err := w.rotateScheduled(); err != nil {
+				log.Error("rotate %s on schedule %s: %s", w.filename, w.schedule, err)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
 func (w *FileWriter) cleanAgedBackups(now time.Time) {
 	dir := filepath.Dir(w.filename)
 	fis, err := w.listBackups()
@@ -219,13 +326,49 @@ func (w *FileWriter) rotate() error {
 	}
 	w.file = nil
 	w.size = 0
+	w.inLine = false
+	w.rotateAtLineEnd = false
+	return w.rename()
+}
+
+// rotateScheduled rotates the log file unless it is empty, even if it is not opened since nothing written.
+// It is put off until the end of the unfinished line.
+func (w *FileWriter) rotateScheduled() error {
+	if w.file != nil {
+		if w.size == 0 {
+			return nil
+		}
+		if w.inLine {
+			w.rotateAtLineEnd = true
+			return nil
+		}
+		return w.rotate()
+	}
+	stat, err := os.Stat(w.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stat.Size() == 0 {
+		return nil
+	}
+	return w.rename()
+}
 
+// rename renames the log file closed to a backup, and compresses and cleans the backups in background.
+func (w *FileWriter) rename() error {
 	rotatedFilename := w.rotatedFilename(timeNow())
 
 	if err := os.Rename(w.filename, rotatedFilename); err != nil {
 		return err
 	}
-	go w.rotateBackground(rotatedFilename)
+	w.backgrounds.Add(1)
+	go func() {
+		defer w.backgrounds.Done()
+		w.rotateBackground(rotatedFilename)
+	}()
 	return nil
 }
 
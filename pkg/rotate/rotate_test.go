@@ -0,0 +1,211 @@
+package rotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setNow makes timeNow return now until the end of the test.
+func setNow(t *testing.T, now time.Time) {
+	t.Helper()
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+}
+
+func newTestWriter(t *testing.T, opts ...Option) *FileWriter {
+	t.Helper()
+	opts = append([]Option{
+		WithFilename(filepath.Join(t.TempDir(), "x.log")),
+		WithMaxBackups(16),
+	}, opts...)
+	w, err := NewFileWriter(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return w
+}
+
+func write(t *testing.T, w *FileWriter, s string) {
+	t.Helper()
+	if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+		t.Fatalf("write %q got %d, %v", s, n, err)
+	}
+}
+
+func rotateScheduled(t *testing.T, w *FileWriter) {
+	t.Helper()
+	w.mu.Lock()
+	err := w.rotateScheduled()
+	w.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertFiles checks the log file and its backups, named with the rotation time, have the content, or not exist
+// if empty.
+func assertFiles(t *testing.T, w *FileWriter, want map[time.Time]string, current string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(w.filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(filepath.Dir(w.filename), entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(content)
+	}
+	wantFiles := make(map[string]string)
+	for rotatedAt, content := range want {
+		wantFiles[filepath.Base(w.rotatedFilename(rotatedAt))] = content
+	}
+	if len(current) > 0 {
+		wantFiles[filepath.Base(w.filename)] = current
+	}
+	for name, content := range wantFiles {
+		if got, ok := files[name]; !ok {
+			t.Errorf("%s not found", name)
+		} else if got != content {
+			t.Errorf("got %s %q, want %q", name, got, content)
+		}
+	}
+	for name := range files {
+		if _, ok := wantFiles[name]; !ok {
+			t.Errorf("unexpected file %s %q", name, files[name])
+		}
+	}
+}
+
+func TestRotateScheduled(t *testing.T) {
+	// rotated by the test instead of the scheduler.
+	w := newTestWriter(t, WithMaxBytes(1024))
+	t1 := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	setNow(t, t1)
+	// neither the missing nor the empty log file is rotated.
+	rotateScheduled(t, w)
+	assertFiles(t, w, nil, "")
+	if err := os.WriteFile(w.filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rotateScheduled(t, w)
+	if _, err := os.Stat(w.filename); err != nil {
+		t.Errorf("empty log file rotated: %s", err)
+	}
+
+	write(t, w, "a\n")
+	rotateScheduled(t, w)
+	assertFiles(t, w, map[time.Time]string{t1: "a\n"}, "")
+
+	// the log file left by the last run, not opened since nothing written, is rotated too.
+	setNow(t, t2)
+	if err := os.WriteFile(w.filename, []byte("left\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rotateScheduled(t, w)
+	assertFiles(t, w, map[time.Time]string{t1: "a\n", t2: "left\n"}, "")
+
+	setNow(t, t3)
+	write(t, w, "b\n")
+	assertFiles(t, w, map[time.Time]string{t1: "a\n", t2: "left\n"}, "b\n")
+	rotateScheduled(t, w)
+	assertFiles(t, w, map[time.Time]string{t1: "a\n", t2: "left\n", t3: "b\n"}, "")
+}
+
+func TestWrite(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		writes []string
+		// backups are the content rotated by the write of the index.
+		backups map[int]string
+		current string
+	}{
+		{
+			name:    "fits in",
+			writes:  []string{"123\n", "45678\n"},
+			current: "123\n45678\n",
+		},
+		{
+			name:    "straddling maxBytes",
+			writes:  []string{"123\n", "4567\n89\n"},
+			backups: map[int]string{1: "123\n4567\n"},
+			current: "89\n",
+		},
+		{
+			name:    "partial line completed beyond maxBytes",
+			writes:  []string{"12345678", "90ab\n", "c\n"},
+			backups: map[int]string{2: "1234567890ab\n"},
+			current: "c\n",
+		},
+		{
+			name:    "line longer than maxBytes",
+			writes:  []string{"a\n", "0123456789abcdef\n", "x\n"},
+			backups: map[int]string{1: "a\n", 2: "0123456789abcdef\n"},
+			current: "x\n",
+		},
+		{
+			name:    "line longer than maxBytes and maxLineBytes",
+			writes:  []string{strings.Repeat("x", 10+maxLineBytes+5) + "\n", "y\n"},
+			backups: map[int]string{0: strings.Repeat("x", 10+maxLineBytes)},
+			current: "xxxxx\ny\n",
+		},
+		{
+			name:    "partial line longer than maxBytes and maxLineBytes",
+			writes:  []string{"a\n", "b", strings.Repeat("c", maxLineBytes+10) + "\n"},
+			backups: map[int]string{2: "a\nb" + strings.Repeat("c", maxLineBytes+7)},
+			current: "ccc\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWriter(t, WithMaxBytes(10))
+			// each write at a different second, so that the backups rotated by them are named differently.
+			for i, s := range tt.writes {
+				setNow(t, t0.Add(time.Duration(i)*time.Second))
+				write(t, w, s)
+			}
+			want := make(map[time.Time]string)
+			for i, content := range tt.backups {
+				want[t0.Add(time.Duration(i)*time.Second)] = content
+			}
+			assertFiles(t, w, want, tt.current)
+		})
+	}
+}
+
+// TestWriteRotateScheduled checks the scheduled rotation is put off until the end of the unfinished line.
+func TestWriteRotateScheduled(t *testing.T) {
+	w := newTestWriter(t, WithMaxBytes(10))
+	t1 := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	setNow(t, t1)
+
+	write(t, w, "a\npar")
+	rotateScheduled(t, w)
+	assertFiles(t, w, nil, "a\npar")
+	write(t, w, "ti")
+	assertFiles(t, w, nil, "a\nparti")
+	write(t, w, "al\nnext\n")
+	assertFiles(t, w, map[time.Time]string{t1: "a\npartial\n"}, "next\n")
+
+	// the line putting off the scheduled rotation is not split beyond maxBytes either.
+	setNow(t, t2)
+	write(t, w, "b")
+	rotateScheduled(t, w)
+	write(t, w, "23456789\nc\n")
+	assertFiles(t, w, map[time.Time]string{t1: "a\npartial\n", t2: "next\nb23456789\n"}, "c\n")
+}
@@ -0,0 +1,120 @@
+package rotate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is when to rotate the log file regardless of its size, in local time.
+type Schedule struct {
+	every Every
+	// weekday is that of weekly.
+	weekday time.Weekday
+	// hour and minute are those of daily and weekly, minute of hourly.
+	hour   int
+	minute int
+}
+
+// Every is the period of a Schedule.
+type Every string
+
+const (
+	EveryHour Every = "hourly"
+	EveryDay  Every = "daily"
+	EveryWeek Every = "weekly"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses a schedule like "hourly", "hourly :30", "daily", "daily 03:00", "weekly" or "weekly mon 03:00".
+// It is at minute 0 of the hour, 00:00 of the day, and Sunday of the week by default.
+func ParseSchedule(s string) (*Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("expected non-empty schedule")
+	}
+	sched := &Schedule{every: Every(fields[0])}
+	args := fields[1:]
+	switch sched.every {
+	case EveryHour:
+		if len(args) > 1 {
+			return nil, fmt.Errorf("expected schedule like 'hourly :30', got %q", s)
+		}
+		if len(args) == 1 {
+			t, err := time.Parse(":04", args[0])
+			if err != nil {
+				return nil, fmt.Errorf("expected minute like ':30', got %q", args[0])
+			}
+			sched.minute = t.Minute()
+		}
+		return sched, nil
+	case EveryDay:
+	case EveryWeek:
+		if len(args) > 0 {
+			weekday, ok := weekdays[strings.ToLower(args[0])]
+			if !ok {
+				return nil, fmt.Errorf("unknown weekday %q, want one of [sun, mon, tue, wed, thu, fri, sat]", args[0])
+			}
+			sched.weekday = weekday
+			args = args[1:]
+		}
+	default:
+		return nil, fmt.Errorf("unknown schedule %q, want one of [%s, %s, %s]", fields[0], EveryHour, EveryDay, EveryWeek)
+	}
+	if len(args) > 1 {
+		return nil, fmt.Errorf("expected schedule like '%s 03:00', got %q", sched.every, s)
+	}
+	if len(args) == 1 {
+		t, err := time.Parse("15:04", args[0])
+		if err != nil {
+			return nil, fmt.Errorf("expected time like '03:00', got %q", args[0])
+		}
+		sched.hour, sched.minute = t.Hour(), t.Minute()
+	}
+	return sched, nil
+}
+
+// Next returns the first time of the schedule after now, in the location of now.
+func (s *Schedule) Next(now time.Time) time.Time {
+	y, m, d := now.Date()
+	var next time.Time
+	switch s.every {
+	case EveryHour:
+		next = time.Date(y, m, d, now.Hour(), s.minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = time.Date(y, m, d, now.Hour()+1, s.minute, 0, 0, now.Location())
+		}
+	case EveryDay:
+		next = time.Date(y, m, d, s.hour, s.minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = time.Date(y, m, d+1, s.hour, s.minute, 0, 0, now.Location())
+		}
+	case EveryWeek:
+		days := (int(s.weekday) - int(now.Weekday()) + 7) % 7
+		next = time.Date(y, m, d+days, s.hour, s.minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = time.Date(y, m, d+days+7, s.hour, s.minute, 0, 0, now.Location())
+		}
+	}
+	return next
+}
+
+// String returns the schedule in the format parsed by ParseSchedule.
+func (s *Schedule) String() string {
+	switch s.every {
+	case EveryHour:
+		return fmt.Sprintf("%s :%02d", s.every, s.minute)
+	case EveryWeek:
+		return fmt.Sprintf("%s %s %02d:%02d", s.every, strings.ToLower(s.weekday.String()[:3]), s.hour, s.minute)
+	}
+	return fmt.Sprintf("%s %02d:%02d", s.every, s.hour, s.minute)
+}
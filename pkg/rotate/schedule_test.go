@@ -0,0 +1,100 @@
+package rotate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		// want is the schedule formatted by String, empty if invalid.
+		want string
+	}{
+		{"hourly", "hourly :00"},
+		{"hourly :30", "hourly :30"},
+		{"daily", "daily 00:00"},
+		{"daily 03:00", "daily 03:00"},
+		{"  daily   23:59 ", "daily 23:59"},
+		{"weekly", "weekly sun 00:00"},
+		{"weekly mon", "weekly mon 00:00"},
+		{"weekly MON 03:00", "weekly mon 03:00"},
+		{"", ""},
+		{"monthly", ""},
+		{"Hourly", ""},
+		{"hourly 30", ""},
+		{"hourly :5", ""},
+		{"hourly :60", ""},
+		{"hourly :30x", ""},
+		{"hourly :30 :40", ""},
+		{"daily 24:00", ""},
+		{"daily 03:00 04:00", ""},
+		{"daily mon 03:00", ""},
+		{"weekly someday 03:00", ""},
+		{"weekly mon 03:00 04:00", ""},
+	}
+	for _, tt := range tests {
+		sched, err := ParseSchedule(tt.schedule)
+		if len(tt.want) == 0 {
+			if err == nil {
+				t.Errorf("ParseSchedule(%q) got %s, want err", tt.schedule, sched)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) got err %s", tt.schedule, err)
+			continue
+		}
+		if got := sched.String(); got != tt.want {
+			t.Errorf("ParseSchedule(%q) got %s, want %s", tt.schedule, got, tt.want)
+		}
+		if again, err := ParseSchedule(sched.String()); err != nil || *again != *sched {
+			t.Errorf("ParseSchedule(%q) got %v, %v, want %v", sched, again, err, sched)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		schedule string
+		now      string
+		want     string
+	}{
+		{"hourly", "2026-10-17 10:30:00", "2026-10-17 11:00:00"},
+		{"hourly :30", "2026-10-17 10:29:59", "2026-10-17 10:30:00"},
+		{"hourly :30", "2026-10-17 10:30:00", "2026-10-17 11:30:00"},
+		{"hourly :30", "2026-10-17 23:45:00", "2026-10-18 00:30:00"},
+		{"hourly", "2026-12-31 23:59:59", "2027-01-01 00:00:00"},
+		{"daily 03:00", "2026-10-17 02:59:59", "2026-10-17 03:00:00"},
+		{"daily 03:00", "2026-10-17 03:00:00", "2026-10-18 03:00:00"},
+		{"daily", "2026-10-31 12:00:00", "2026-11-01 00:00:00"},
+		{"daily", "2024-02-28 00:00:01", "2024-02-29 00:00:00"},
+		{"daily 23:30", "2026-12-31 23:30:00", "2027-01-01 23:30:00"},
+		// 2026-10-17 is a Saturday.
+		{"weekly mon 03:00", "2026-10-17 10:00:00", "2026-10-19 03:00:00"},
+		{"weekly sat 10:00", "2026-10-17 09:00:00", "2026-10-17 10:00:00"},
+		{"weekly sat 10:00", "2026-10-17 10:00:00", "2026-10-24 10:00:00"},
+		{"weekly", "2026-10-18 00:00:00", "2026-10-25 00:00:00"},
+		{"weekly fri", "2026-10-30 12:00:00", "2026-11-06 00:00:00"},
+		// 2026-01-01 is a Thursday.
+		{"weekly thu 08:00", "2025-12-31 09:00:00", "2026-01-01 08:00:00"},
+	}
+	for _, tt := range tests {
+		sched, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sched.Next(at(tt.now)), at(tt.want); !got.Equal(want) || got.Location() != loc {
+			t.Errorf("%s Next(%s) got %s, want %s", tt.schedule, tt.now, got, want)
+		}
+	}
+}
@@ -22,3 +22,10 @@ func SetupSignalHandler() <-chan struct{} {
 	}()
 	return stop
 }
+
+// SetupReloadSignalHandler registered for SIGHUP. A channel is returned which receives on each SIGHUP.
+func SetupReloadSignalHandler() <-chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	return c
+}
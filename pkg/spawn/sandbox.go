@@ -0,0 +1,108 @@
+package spawn
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// sandbox sets up the mounts and loopback device of the namespaces the child process started in.
+func (a *Attr) sandbox() error {
+	if a.Cloneflags&syscall.CLONE_NEWNS != 0 {
+		// so that no mount below propagates back to the host.
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("make mounts private: %s", err)
+		}
+		root := a.Chroot
+		if len(root) == 0 {
+			root = "/"
+		}
+		if a.Cloneflags&syscall.CLONE_NEWPID != 0 {
+			// /proc of the pid namespace, not of the host.
+			if err := syscall.Mount("proc", filepath.Join(root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+				return fmt.Errorf("mount /proc: %s", err)
+			}
+		}
+		if a.Cloneflags&syscall.CLONE_NEWNET != 0 {
+			// /sys of the net namespace, showing its own net devices.
+			if err := syscall.Mount("sysfs", filepath.Join(root, "sys"), "sysfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC|syscall.MS_RDONLY, ""); err != nil {
+				return fmt.Errorf("mount /sys: %s", err)
+			}
+		}
+		if a.PrivateTmp {
+			if err := syscall.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+				return fmt.Errorf("mount /tmp: %s", err)
+			}
+		}
+		for _, path := range a.ReadOnlyPaths {
+			if err := bindReadOnly(filepath.Join(root, path)); err != nil {
+				return err
+			}
+		}
+	}
+	if a.Cloneflags&syscall.CLONE_NEWNET != 0 {
+		if err := setLoopbackUp(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chroot changes the root directory, after which the files of the host like /proc may be gone.
+func (a *Attr) chroot() error {
+	if err := syscall.Chroot(a.Chroot); err != nil {
+		return fmt.Errorf("chroot %s: %s", a.Chroot, err)
+	}
+	dir := a.Dir
+	if len(dir) == 0 {
+		dir = "/"
+	}
+	if err := syscall.Chdir(dir); err != nil {
+		return fmt.Errorf("chdir %s: %s", dir, err)
+	}
+	return nil
+}
+
+// bindReadOnly bind mounts path to itself, and remounts it read-only.
+func bindReadOnly(path string) error {
+	if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount %s: %s", path, err)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return fmt.Errorf("statfs %s: %s", path, err)
+	}
+	// the flags like nosuid locked by the original mount have to be kept.
+	flags := uintptr(stat.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+	if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %s", path, err)
+	}
+	return nil
+}
+
+// ifreq is struct ifreq of SIOCGIFFLAGS and SIOCSIFFLAGS in linux/if.h.
+type ifreq struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// setLoopbackUp brings up the loopback device, which is down in a new net namespace.
+func setLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("create socket: %s", err)
+	}
+	defer syscall.Close(fd)
+	req := ifreq{}
+	copy(req.name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("get flags of lo: %s", errno)
+	}
+	req.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("set lo up: %s", errno)
+	}
+	return nil
+}
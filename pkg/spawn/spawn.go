@@ -0,0 +1,289 @@
+package spawn
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Sup re-executes itself in the child process to apply the attributes exec.Cmd cannot, like rlimits and
+// oom_score_adj, right before executing the program, so that the program starts with them in place.
+
+// envAttr is the env passing the Attr to the child, removed before executing the program.
+const envAttr = "SUP_SPAWN_ATTR"
+
+// exitCode of the child failed to apply the Attr or execute the program, the same as a shell command not found.
+const exitCode = 127
+
+// prctl(2) options and capget(2) version, see linux/prctl.h and linux/capability.h.
+const (
+	prSetKeepCaps       = 8
+	prCapBSetDrop       = 24
+	prSetNoNewPrivs     = 38
+	prCapAmbient        = 47
+	prCapAmbientRaise   = 2
+	capabilityVersion3  = 0x20080522
+	capLastCapPath      = "/proc/sys/kernel/cap_last_cap"
+	capabilityDataCount = 2
+)
+
+// ioprio doc: https://man7.org/linux/man-pages/man2/ioprio_set.2.html
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// Attr is applied to the child process before executing the program.
+type Attr struct {
+	// Path of the program, the child process executes sup itself at first.
+	Path    string   `json:"path"`
+	Rlimits []Rlimit `json:"rlimits,omitempty"`
+	// Nice, IOPriority, Umask and OOMScoreAdj are inherited from sup if nil.
+	Nice *int `json:"nice,omitempty"`
+	// IOPriority is the value of ioprio_set(2), combining the class and priority.
+	IOPriority  *int `json:"ioPriority,omitempty"`
+	Umask       *int `json:"umask,omitempty"`
+	OOMScoreAdj *int `json:"oomScoreAdj,omitempty"`
+	// Cloneflags are the CLONE_NEW* flags of syscall.SysProcAttr the child process started with.
+	Cloneflags uintptr `json:"cloneflags,omitempty"`
+	// PrivateTmp and ReadOnlyPaths are applied in the mount namespace, under Chroot if given.
+	PrivateTmp    bool     `json:"privateTmp,omitempty"`
+	ReadOnlyPaths []string `json:"readOnlyPaths,omitempty"`
+	// Chroot and Dir are the same as syscall.SysProcAttr.Chroot and exec.Cmd.Dir.
+	Chroot string `json:"chroot,omitempty"`
+	Dir    string `json:"dir,omitempty"`
+	// BoundingCaps are the capabilities kept in the bounding set, nil to keep all.
+	BoundingCaps    []uintptr `json:"boundingCaps"`
+	NoNewPrivileges bool      `json:"noNewPrivileges,omitempty"`
+	// Credential and AmbientCaps are set last, after which the privileges for the above may be gone.
+	// They are the same as those of syscall.SysProcAttr.
+	Credential  *syscall.Credential `json:"credential,omitempty"`
+	AmbientCaps []uintptr           `json:"ambientCaps,omitempty"`
+}
+
+// Rlimit is a resource limit set by setrlimit(2).
+type Rlimit struct {
+	Resource int    `json:"resource"`
+	Cur      uint64 `json:"cur"`
+	Max      uint64 `json:"max"`
+}
+
+// IOPriority returns the ioprio_set(2) value of the IO scheduling class and priority.
+func IOPriority(class, priority int) int {
+	return class<<ioprioClassShift | priority
+}
+
+// Empty reports whether nothing needs to be applied by the child process.
+func (a *Attr) Empty() bool {
+	return len(a.Rlimits) == 0 && a.Nice == nil && a.IOPriority == nil && a.Umask == nil && a.OOMScoreAdj == nil &&
+		a.BoundingCaps == nil && !a.NoNewPrivileges && a.Cloneflags&(syscall.CLONE_NEWNS|syscall.CLONE_NEWNET) == 0
+}
+
+// Wrap makes cmd execute sup itself to apply attr and then the program. Nothing changed if attr is empty.
+// The chroot, credential and ambient capabilities of cmd are moved into attr, since they are set by the child process
+// after the others, so is the dir of cmd if chroot given.
+func Wrap(cmd *exec.Cmd, attr *Attr) error {
+	if attr.Empty() {
+		return nil
+	}
+	wrapped := *attr
+	wrapped.Path = cmd.Path
+	if cmd.SysProcAttr != nil {
+		// SysProcAttr may be shared with other cmds.
+		sysProcAttr := *cmd.SysProcAttr
+		wrapped.Credential, wrapped.AmbientCaps = sysProcAttr.Credential, sysProcAttr.AmbientCaps
+		sysProcAttr.Credential, sysProcAttr.AmbientCaps = nil, nil
+		if len(sysProcAttr.Chroot) > 0 {
+			wrapped.Chroot, wrapped.Dir = sysProcAttr.Chroot, cmd.Dir
+			sysProcAttr.Chroot, cmd.Dir = "", ""
+		}
+		cmd.SysProcAttr = &sysProcAttr
+	}
+	value, err := json.Marshal(&wrapped)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spawn attr: %s", err)
+	}
+	cmd.Path = "/proc/self/exe"
+	cmd.Env = append(append([]string(nil), cmd.Env...), envAttr+"="+string(value))
+	return nil
+}
+
+// Main applies the Attr and executes the program if sup is started by Wrap, otherwise returns immediately.
+// It should be called first in main.
+func Main() {
+	value, ok := os.LookupEnv(envAttr)
+	if !ok {
+		return
+	}
+	// nice, ioprio, capabilities and no_new_privs are of the thread, which has to be the one executing the program.
+	runtime.LockOSThread()
+	if err := os.Unsetenv(envAttr); err != nil {
+		fatal("unset env %s: %s", envAttr, err)
+	}
+	attr := &Attr{}
+	if err := json.Unmarshal([]byte(value), attr); err != nil {
+		fatal("unmarshal spawn attr: %s", err)
+	}
+	if err := attr.apply(); err != nil {
+		fatal("%s", err)
+	}
+	err := syscall.Exec(attr.Path, os.Args, os.Environ())
+	fatal("exec %s: %s", attr.Path, err)
+}
+
+func (a *Attr) apply() error {
+	if err := a.sandbox(); err != nil {
+		return err
+	}
+	if a.Umask != nil {
+		syscall.Umask(*a.Umask)
+	}
+	for _, rlimit := range a.Rlimits {
+		if err := syscall.Setrlimit(rlimit.Resource, &syscall.Rlimit{Cur: rlimit.Cur, Max: rlimit.Max}); err != nil {
+			return fmt.Errorf("setrlimit %d: %s", rlimit.Resource, err)
+		}
+	}
+	if a.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *a.Nice); err != nil {
+			return fmt.Errorf("set nice %d: %s", *a.Nice, err)
+		}
+	}
+	if a.IOPriority != nil {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(*a.IOPriority)); errno != 0 {
+			return fmt.Errorf("set ioprio %d: %s", *a.IOPriority, errno)
+		}
+	}
+	if a.OOMScoreAdj != nil {
+		if err := os.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*a.OOMScoreAdj)), 0644); err != nil {
+			return fmt.Errorf("set oom_score_adj %d: %s", *a.OOMScoreAdj, err)
+		}
+	}
+	if a.BoundingCaps != nil {
+		if err := dropBoundingCaps(a.BoundingCaps); err != nil {
+			return err
+		}
+	}
+	if a.NoNewPrivileges {
+		if err := prctl(prSetNoNewPrivs, 1, 0); err != nil {
+			return fmt.Errorf("set no_new_privs: %s", err)
+		}
+	}
+	if len(a.Chroot) > 0 {
+		if err := a.chroot(); err != nil {
+			return err
+		}
+	}
+	if len(a.AmbientCaps) > 0 {
+		// keeps the permitted capabilities after setuid.
+		if err := prctl(prSetKeepCaps, 1, 0); err != nil {
+			return fmt.Errorf("set keepcaps: %s", err)
+		}
+	}
+	if cred := a.Credential; cred != nil {
+		// the same as what syscall.SysProcAttr.Credential does.
+		if !cred.NoSetGroups {
+			groups := make([]int, 0, len(cred.Groups))
+			for _, gid := range cred.Groups {
+				groups = append(groups, int(gid))
+			}
+			if err := syscall.Setgroups(groups); err != nil {
+				return fmt.Errorf("setgroups %v: %s", groups, err)
+			}
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			return fmt.Errorf("setgid %d: %s", cred.Gid, err)
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			return fmt.Errorf("setuid %d: %s", cred.Uid, err)
+		}
+	}
+	if len(a.AmbientCaps) > 0 {
+		if err := raiseAmbientCaps(a.AmbientCaps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropBoundingCaps drops the capabilities not in keep from the bounding set.
+func dropBoundingCaps(keep []uintptr) error {
+	content, err := os.ReadFile(capLastCapPath)
+	if err != nil {
+		return fmt.Errorf("read %s: %s", capLastCapPath, err)
+	}
+	lastCap, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", capLastCapPath, content)
+	}
+	for c := uintptr(0); c <= uintptr(lastCap); c++ {
+		if containsCap(keep, c) {
+			continue
+		}
+		if err := prctl(prCapBSetDrop, c, 0); err != nil {
+			return fmt.Errorf("drop capability %d from bounding set: %s", c, err)
+		}
+	}
+	return nil
+}
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// raiseAmbientCaps raises the capabilities in the ambient set, the same as what syscall.SysProcAttr.AmbientCaps does.
+func raiseAmbientCaps(caps []uintptr) error {
+	hdr := capHeader{version: capabilityVersion3}
+	var data [capabilityDataCount]capData
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capget: %s", errno)
+	}
+	for _, c := range caps {
+		// a capability has to be both permitted and inheritable to be ambient.
+		data[c>>5].permitted |= 1 << (c & 31)
+		data[c>>5].inheritable |= 1 << (c & 31)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %s", errno)
+	}
+	for _, c := range caps {
+		if err := prctl(prCapAmbient, prCapAmbientRaise, c); err != nil {
+			return fmt.Errorf("raise ambient capability %d: %s", c, err)
+		}
+	}
+	return nil
+}
+
+func prctl(option, arg2, arg3 uintptr) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, option, arg2, arg3); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func containsCap(caps []uintptr, c uintptr) bool {
+	for _, k := range caps {
+		if k == c {
+			return true
+		}
+	}
+	return false
+}
+
+// fatal reports to stderr, which is the log of the program.
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sup: "+format+"\n", args...)
+	os.Exit(exitCode)
+}